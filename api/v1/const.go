@@ -1,30 +1,220 @@
 package v1
 
 const (
-	ModeIngress  = "ingress"
-	ModeNodePort = "nodeport"
-)
-
-const (
-	ConditionTypeDeployment = "Deployment"
-	ConditionTypeService    = "Service"
-	ConditionTypeIngress    = "Ingress"
-
-	ConditionMessageDeploymentOKFmt  = "Deployment %s is ready"
-	ConditionMessageDeploymentNotFmt = "Deployment %s is not ready"
-	ConditionMessageServiceOKFmt     = "Service %s is ready"
-	ConditionMessageServiceNotFmt    = "Service %s is not ready"
-	ConditionMessageIngressOKFmt     = "Ingress %s is ready"
-	ConditionMessageIngressNotFmt    = "Ingress %s is not ready"
-
-	ConditionReasonDeploymentReady    = "DeploymentReady"
-	ConditionReasonDeploymentNotReady = "DeploymentNotReady"
-	ConditionReasonServiceReady       = "ServiceReady"
-	ConditionReasonServiceNotReady    = "ServiceNotReady"
-	ConditionReasonIngressReady       = "IngressReady"
-	ConditionReasonIngressNotReady    = "IngressNotReady"
-	ConditionStatusTrue               = "True"
-	ConditionStatusFalse              = "False"
+	ModeIngress      = "ingress"
+	ModeNodePort     = "nodeport"
+	ModeLoadBalancer = "loadbalancer"
+	ModeClusterIP    = "clusterip"
+	ModeGateway      = "gateway"
+)
+
+const (
+	GatewayPathPrefix            = "PathPrefix"
+	GatewayPathExact             = "Exact"
+	GatewayPathRegularExpression = "RegularExpression"
+)
+
+// ImagePolicyPinDigest 把镜像的 tag 解析为摘要
+const ImagePolicyPinDigest = "pinDigest"
+
+// DefaultIngressControllerNamespace networkPolicy 中 ingress controller 默认所在的 namespace
+const DefaultIngressControllerNamespace = "ingress-nginx"
+
+// DefaultPortName 只填写 port 时生成的端口名称
+const DefaultPortName = "http"
+
+const (
+	WorkloadKindDeployment  = "Deployment"
+	WorkloadKindStatefulSet = "StatefulSet"
+)
+
+const (
+	CanaryPhaseProgressing = "Progressing"
+	CanaryPhasePaused      = "Paused"
+	CanaryPhasePromoting   = "Promoting"
+	CanaryPhasePromoted    = "Promoted"
+	CanaryPhaseAborted     = "Aborted"
+
+	// AnnotationCanaryPromote 结束金丝雀发布的当前步骤, 处理后由 operator 删除
+	AnnotationCanaryPromote = "apps.zwh.com/canary-promote"
+	// AnnotationCanaryAbort 中止金丝雀发布, 处理后由 operator 删除
+	AnnotationCanaryAbort = "apps.zwh.com/canary-abort"
+)
+
+// AnnotationRollbackTo 把 spec 回滚到指定的历史版本, 值为版本号, 处理后由 operator 删除
+const AnnotationRollbackTo = "apps.zwh.com/rollback-to"
+
+const (
+	RolloutPhaseProgressing = "Progressing"
+	RolloutPhaseComplete    = "Complete"
+	RolloutPhaseFailed      = "Failed"
+	RolloutPhaseRolledBack  = "RolledBack"
+)
+
+const (
+	BlueGreenPhasePreviewing = "Previewing"
+	BlueGreenPhasePaused     = "Paused"
+	BlueGreenPhaseSwitched   = "Switched"
+	BlueGreenPhasePromoting  = "Promoting"
+	BlueGreenPhasePromoted   = "Promoted"
+	BlueGreenPhaseAborted    = "Aborted"
+
+	BlueGreenColorBlue  = "blue"
+	BlueGreenColorGreen = "green"
+
+	// AnnotationBlueGreenPromote 把流量切换到 preview, 或者跳过 scaleDownDelay, 处理后由 operator 删除
+	AnnotationBlueGreenPromote = "apps.zwh.com/bluegreen-promote"
+	// AnnotationBlueGreenAbort 中止蓝绿发布, 流量切回原来的版本, 处理后由 operator 删除
+	AnnotationBlueGreenAbort = "apps.zwh.com/bluegreen-abort"
+)
+
+const (
+	SecurityProfilePrivileged = "privileged"
+	SecurityProfileBaseline   = "baseline"
+	SecurityProfileRestricted = "restricted"
+)
+
+const (
+	SizeSmall  = "small"
+	SizeMedium = "medium"
+	SizeLarge  = "large"
+)
+
+const (
+	StorageReclaimRetain = "retain"
+	StorageReclaimDelete = "delete"
+
+	// AnnotationStorageOwner operator 创建的 pvc 上记录所属的 ZwhDeployment 名称
+	// reclaimPolicy 为 retain 时 pvc 没有 controller 引用, 用它判断 pvc 是否由 operator 管理
+	AnnotationStorageOwner = "apps.zwh.com/storage-owner"
+)
+
+const (
+	TlsIssuerSelfSigned    = "selfsigned"
+	TlsIssuerIssuer        = "issuer"
+	TlsIssuerClusterIssuer = "clusterissuer"
+	TlsIssuerSecret        = "secret"
+)
+
+const (
+	ConditionTypeDeployment  = "Deployment"
+	ConditionTypeService     = "Service"
+	ConditionTypeIngress     = "Ingress"
+	ConditionTypeCertificate = "Certificate"
+	ConditionTypeStorage     = "Storage"
+	ConditionTypeStatefulSet = "StatefulSet"
+	ConditionTypeHPA         = "HorizontalPodAutoscaler"
+	ConditionTypePDB         = "PodDisruptionBudget"
+	ConditionTypeConfig      = "Config"
+	ConditionTypeCanary      = "Canary"
+	ConditionTypeBlueGreen   = "BlueGreen"
+	ConditionTypePodSecurity = "PodSecurity"
+	// ServiceAccount 和 rbac 的 Role、RoleBinding 对应一个 condition
+	ConditionTypeServiceAccount = "ServiceAccount"
+	ConditionTypeRegistry       = "RegistryCredentials"
+	ConditionTypeNetworkPolicy  = "NetworkPolicy"
+	ConditionTypeImage          = "Image"
+	// HTTPRoute 的 Accepted 和 ResolvedRefs 分别对应一个 condition
+	ConditionTypeRouteAccepted     = "HTTPRouteAccepted"
+	ConditionTypeRouteResolvedRefs = "HTTPRouteResolvedRefs"
+
+	ConditionMessageDeploymentOKFmt   = "Deployment %s is ready"
+	ConditionMessageDeploymentNotFmt  = "Deployment %s is not ready"
+	ConditionMessageServiceOKFmt      = "Service %s is ready"
+	ConditionMessageServiceNotFmt     = "Service %s is not ready"
+	ConditionMessageServiceLBFmt      = "Service %s is waiting for the load balancer to assign an external address"
+	ConditionMessageIngressOKFmt      = "Ingress %s is ready"
+	ConditionMessageIngressNotFmt     = "Ingress %s is not ready"
+	ConditionMessageCertOKFmt         = "Certificate %s is ready"
+	ConditionMessageCertNotFmt        = "Certificate %s is not ready"
+	ConditionMessageStorageOKFmt      = "Storage %s is ready"
+	ConditionMessageStorageNotFmt     = "PersistentVolumeClaim %s is %s"
+	ConditionMessageStorageOwnedFmt   = "PersistentVolumeClaim %s already exists and is not managed by %s"
+	ConditionMessageStatefulSetOKFmt  = "StatefulSet %s is ready"
+	ConditionMessageStatefulSetNotFmt = "StatefulSet %s is not ready"
+	ConditionMessageHPAOKFmt          = "HorizontalPodAutoscaler %s is active"
+	ConditionMessageHPANotFmt         = "HorizontalPodAutoscaler %s is not active"
+	ConditionMessagePDBOKFmt          = "PodDisruptionBudget %s is ready"
+	ConditionMessagePDBNotFmt         = "PodDisruptionBudget %s has %d healthy pods, %d desired"
+	ConditionMessageRouteNotFmt       = "HTTPRoute %s has not been processed by the gateway controller"
+	ConditionMessageConfigOKFmt       = "Config of %s is ready"
+	ConditionMessageConfigNotFmt      = "%s %s referenced by %s is not found"
+	ConditionMessageCanaryStepFmt     = "Canary %s is at step %d/%d with %d%% of the traffic"
+	ConditionMessageCanaryPausedFmt   = "Canary %s is paused at step %d/%d, add the %s annotation to continue"
+	ConditionMessageCanaryRolloutFmt  = "Canary %s is promoted, waiting for the stable deployment to roll out"
+	ConditionMessageCanaryOKFmt       = "Canary %s is promoted"
+	ConditionMessageRolloutFailedFmt  = "Deployment %s failed to roll out image %s: %s"
+	ConditionMessageRolledBackFmt     = "Deployment %s is rolled back to image %s, image %s failed: %s"
+	ConditionMessageBlueGreenNotFmt   = "Preview %s of %s is not ready"
+	ConditionMessageBlueGreenPauseFmt = "Preview %s of %s is ready, add the %s annotation to promote"
+	ConditionMessageBlueGreenSwapFmt  = "Traffic of %s is switched to %s, %s will be scaled down after %s"
+	ConditionMessageBlueGreenRollFmt  = "Traffic of %s is switched to %s, waiting for the deployment to roll out"
+	ConditionMessageBlueGreenOKFmt    = "%s is promoted to %s"
+	ConditionMessageSecurityOKFmt     = "Pod template of %s satisfies the %s profile"
+	ConditionMessageSecurityNotFmt    = "Pod template of %s violates the %s profile: %s"
+	ConditionMessageSAOKFmt           = "ServiceAccount %s is ready"
+	ConditionMessageSANotFmt          = "ServiceAccount %s referenced by %s is not found"
+	ConditionMessageSAOwnedFmt        = "%s %s already exists and is not managed by %s"
+	ConditionMessageRegistryOKFmt     = "Registry credentials %s are copied to %s"
+	ConditionMessageRegistryNotFmt    = "Registry credentials %s referenced by %s are not found"
+	ConditionMessageRegistryTypeFmt   = "Registry credentials %s have type %s, want %s or %s"
+	ConditionMessageRegistryOffFmt    = "Registry credentials %s referenced by %s can not be copied, no central namespace is configured for the operator"
+	ConditionMessageRegistryOwnedFmt  = "Secret %s already exists and is not managed by %s"
+	ConditionMessageImageOKFmt        = "Image %s is pinned to %s"
+	ConditionMessageImageNotFmt       = "Image %s can not be resolved to a digest: %s"
+	ConditionMessagePolicyOKFmt       = "NetworkPolicy %s is applied"
+	ConditionMessagePolicyOwnedFmt    = "NetworkPolicy %s already exists and is not managed by %s"
+
+	ConditionReasonDeploymentReady     = "DeploymentReady"
+	ConditionReasonDeploymentNotReady  = "DeploymentNotReady"
+	ConditionReasonProbeFailed         = "DeploymentProbeFailed"
+	ConditionReasonRolloutFailed       = "RolloutFailed"
+	ConditionReasonRolledBack          = "RolledBack"
+	ConditionReasonServiceReady        = "ServiceReady"
+	ConditionReasonServiceNotReady     = "ServiceNotReady"
+	ConditionReasonIngressReady        = "IngressReady"
+	ConditionReasonIngressNotReady     = "IngressNotReady"
+	ConditionReasonCertReady           = "CertificateReady"
+	ConditionReasonCertNotReady        = "CertificateNotReady"
+	ConditionReasonStorageReady        = "StorageReady"
+	ConditionReasonStorageNotReady     = "StorageNotReady"
+	ConditionReasonStorageConflict     = "StorageConflict"
+	ConditionReasonStatefulSetReady    = "StatefulSetReady"
+	ConditionReasonStatefulSetNotReady = "StatefulSetNotReady"
+	ConditionReasonHPAActive           = "HPAScalingActive"
+	ConditionReasonHPANotActive        = "HPAScalingInactive"
+	ConditionReasonPDBReady            = "PDBReady"
+	ConditionReasonPDBNotReady         = "PDBNotReady"
+	ConditionReasonRoutePending        = "Pending"
+	ConditionReasonConfigReady         = "ConfigReady"
+	ConditionReasonConfigNotFound      = "ConfigNotFound"
+	ConditionReasonCanaryProgressing   = "CanaryProgressing"
+	ConditionReasonCanaryPaused        = "CanaryPaused"
+	ConditionReasonCanaryPromoted      = "CanaryPromoted"
+	ConditionReasonCanaryAborted       = "CanaryAborted"
+	ConditionReasonBlueGreenPreviewing = "BlueGreenPreviewing"
+	ConditionReasonBlueGreenPaused     = "BlueGreenPaused"
+	ConditionReasonBlueGreenSwitched   = "BlueGreenSwitched"
+	ConditionReasonBlueGreenPromoting  = "BlueGreenPromoting"
+	ConditionReasonBlueGreenPromoted   = "BlueGreenPromoted"
+	ConditionReasonBlueGreenAborted    = "BlueGreenAborted"
+	ConditionReasonSecurityCompliant   = "PodSecurityCompliant"
+	ConditionReasonSecurityViolation   = "PodSecurityViolation"
+	ConditionReasonSAReady             = "ServiceAccountReady"
+	ConditionReasonSANotFound          = "ServiceAccountNotFound"
+	ConditionReasonSAConflict          = "ServiceAccountConflict"
+	ConditionReasonRegistryReady       = "RegistryCredentialsReady"
+	ConditionReasonRegistryNotFound    = "RegistryCredentialsNotFound"
+	ConditionReasonRegistryInvalid     = "RegistryCredentialsInvalid"
+	ConditionReasonRegistryDisabled    = "RegistryCredentialsDisabled"
+	ConditionReasonRegistryConflict    = "RegistryCredentialsConflict"
+	ConditionReasonImagePinned         = "ImagePinned"
+	ConditionReasonImageResolveFailed  = "ImageResolveFailed"
+	ConditionReasonPolicyReady         = "NetworkPolicyReady"
+	ConditionReasonPolicyNotReady      = "NetworkPolicyNotReady"
+	ConditionReasonPolicyConflict      = "NetworkPolicyConflict"
+	ConditionStatusTrue                = "True"
+	ConditionStatusFalse               = "False"
 )
 
 const (
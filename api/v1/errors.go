@@ -3,3 +3,5 @@ package v1
 import "fmt"
 
 var ErrorNotSupportMode = fmt.Errorf("Not support this mode ")
+
+var ErrorNotSupportSize = fmt.Errorf("Not support this size ")
@@ -17,8 +17,12 @@ limitations under the License.
 package v1
 
 import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -28,11 +32,33 @@ import (
 type ZwhDeploymentSpec struct {
 	//Image 存储镜像地址
 	Image string `json:"image"`
-	//Port 存储服务提供的端口
-	Port int32 `json:"port"`
-	//Replicas 存储要部署多少个副本
+	//ImagePolicy 镜像的处理方式,填写 pinDigest 时由operator把主容器镜像的 tag 解析为摘要,pod 使用带摘要的镜像
+	//同一个 image 只解析一次,tag 指向新的镜像后需要修改 image 才会更新
+	//+kubebuilder:validation:Enum=pinDigest
+	//+optional
+	ImagePolicy string `json:"imagePolicy,omitempty"`
+	//ImagePullSecrets 拉取镜像使用的已有secret,直接使用pod中的定义方式
+	//+optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	//RegistryCredentials 引用集中管理的 namespace 中的镜像仓库凭证,由operator复制到当前 namespace 并保持同步
+	//集中管理的 namespace 由 operator 的 --registry-credentials-namespace 参数指定
+	//复制的secret名称为 <ZwhDeployment名称>-registry,自动添加到 imagePullSecrets
+	//+optional
+	RegistryCredentials *RegistryCredentials `json:"registryCredentials,omitempty"`
+	//Port 存储服务提供的端口.填写了ports时用来指定主端口,未填写时默认为ports中的第一个端口
+	//+optional
+	Port int32 `json:"port,omitempty"`
+	//Ports 存储容器的多个命名端口,例如 metrics 端口或管理端口.未填写时由port生成一个名为http的端口
+	//ingress、gateway 和默认的健康检查使用主端口
+	//+optional
+	Ports []ContainerPort `json:"ports,omitempty"`
+	//Replicas 存储要部署多少个副本,未填写时默认为1
 	//+optional
 	Replicas int32 `json:"replicas,omitempty"`
+	//WorkloadKind 工作负载类型 Deployment or StatefulSet,默认为 Deployment
+	//+kubebuilder:validation:Enum=Deployment;StatefulSet
+	//+optional
+	WorkloadKind string `json:"workloadKind,omitempty"`
 	//StartCmd 存储启动命令
 	//+optional
 	StartCmd string `json:"startCmd,omitempty"`
@@ -40,27 +66,466 @@ type ZwhDeploymentSpec struct {
 	//+optional
 	Args []string `json:"args,omitempty"`
 	//Volumes	存储存储卷，直接使用pod中的定义方式
-	//Volumes []corev1.Volume
-	////VolumeMounts 存储存储卷挂载，直接使用pod中的定义方式
-	//VolumeMounts []corev1.VolumeMount
+	//+optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+	//VolumeMounts 存储存储卷挂载，直接使用pod中的定义方式
+	//+optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	//Sidecars 存储和主容器一起运行的边车容器,例如日志采集、认证代理,直接使用pod中的定义方式
+	//可以挂载volumes和storage中的存储卷,和主容器共享数据
+	//+optional
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+	//InitContainers 存储在主容器之前按顺序运行的初始化容器,例如数据库迁移,直接使用pod中的定义方式
+	//+optional
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+	//Storage 存储由operator创建和管理的pvc
+	//+optional
+	Storage *Storage `json:"storage,omitempty"`
 	//Environments 存储环境变量，直接使用pod中的定义方式
 	Environments []corev1.EnvVar `json:"environments,omitempty"`
+	//Config 存储由operator生成的ConfigMap,名称为 <ZwhDeployment名称>-config.内容变化时自动滚动更新pod
+	//+optional
+	Config *Config `json:"config,omitempty"`
+	//SecretRefs 存储引用的已有secret,挂载或注入到主容器.内容变化时自动滚动更新pod
+	//+optional
+	SecretRefs []SecretRef `json:"secretRefs,omitempty"`
+	//EnvFrom 存储主容器批量注入的环境变量,直接使用pod中的定义方式.引用的configmap和secret变化时自动滚动更新pod
+	//+optional
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+	//Resources 存储容器的资源请求和限制，直接使用pod中的定义方式.会覆盖size预设中相同的资源
+	//+optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	//Size 资源规格预设 small, medium or large,由operator换算成具体的requests和limits
+	//+kubebuilder:validation:Enum=small;medium;large
+	//+optional
+	Size string `json:"size,omitempty"`
+	//Probes 存储健康检查配置,都没有填写时默认对port做tcp检查
+	//+optional
+	Probes *Probes `json:"probes,omitempty"`
+	//Autoscaling 存储hpa配置,开启后副本数由hpa控制,replicas不再生效
+	//+optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+	//DisruptionBudget 存储pdb配置,不填写时副本数大于1会默认生成 maxUnavailable 为1的pdb
+	//+optional
+	DisruptionBudget *DisruptionBudget `json:"disruptionBudget,omitempty"`
+	//NodeSelector pod 只调度到带有这些标签的节点
+	//+optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	//Tolerations 容忍节点的污点,直接使用pod中的定义方式
+	//+optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	//Affinity 节点亲和性和 pod 亲和性,直接使用pod中的定义方式
+	//+optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	//TopologySpreadConstraints pod 在可用区、节点等拓扑域之间的分布,直接使用pod中的定义方式
+	//+optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	//PriorityClassName pod 使用的优先级
+	//+optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	//SpreadAcrossZones 把 pod 尽量平均分散到不同的可用区和节点,资源不足时仍然可以调度
+	//topologySpreadConstraints 中已经填写的拓扑域不会重复生成
+	//+optional
+	SpreadAcrossZones bool `json:"spreadAcrossZones,omitempty"`
+	//SecurityContext 主容器的安全配置,直接使用pod中的定义方式
+	//+optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	//PodSecurityContext pod 的安全配置,直接使用pod中的定义方式
+	//+optional
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	//SecurityProfile pod 需要满足的 Pod Security Standards 等级 privileged、baseline 或 restricted,默认为 privileged
+	//restricted 时补全没有填写的安全配置: runAsNonRoot、禁止提权、删除所有 capabilities、seccomp RuntimeDefault、只读根文件系统
+	//pod 不满足选择的等级时不会创建或更新 deployment/statefulset
+	//+kubebuilder:validation:Enum=privileged;baseline;restricted
+	//+optional
+	SecurityProfile string `json:"securityProfile,omitempty"`
+	//ServiceAccount pod 使用的 ServiceAccount,不填写时使用 namespace 的 default
+	//+optional
+	ServiceAccount *ServiceAccount `json:"serviceAccount,omitempty"`
+	//RBAC 授予 ServiceAccount 的权限,由operator生成同名的 Role 和 RoleBinding,需要同时填写 serviceAccount
+	//+optional
+	RBAC *RBAC `json:"rbac,omitempty"`
+	//NetworkPolicy 存储由operator生成的 NetworkPolicy,只允许声明的来源访问 service 暴露的端口
+	//+optional
+	NetworkPolicy *NetworkPolicy `json:"networkPolicy,omitempty"`
+	//ProgressDeadlineSeconds deployment 超过这个时间没有完成滚动更新时认为发布失败,默认为600
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	//AutoRollback 发布失败时自动回滚到上一次成功发布的 pod 模板,修改 spec 后重新发布.只支持 Deployment
+	//+optional
+	AutoRollback bool `json:"autoRollback,omitempty"`
+	//RevisionHistoryLimit 保留的 spec 历史版本数量,默认为10.添加 apps.zwh.com/rollback-to 注解时回滚到指定的版本
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	//Strategy 发布策略,不填写时直接滚动更新 deployment
+	//+optional
+	Strategy *Strategy `json:"strategy,omitempty"`
 	//Expose service要暴露的端口
 	Expose *Expose `json:"expose"`
 }
 
+// Strategy 存储发布策略
+type Strategy struct {
+	//Canary 金丝雀发布,镜像变化时先运行一个 canary deployment,按照步骤逐步把流量切过去
+	//只支持 workloadKind 为 Deployment 且 mode 为 ingress,流量由 ingress-nginx 的 canary 注解控制
+	//+optional
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+	//BlueGreen 蓝绿发布,镜像变化时先完整运行一个 preview deployment,推广时把 service 一次性切换过去
+	//只支持 workloadKind 为 Deployment,不能和 canary 同时使用
+	//+optional
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// BlueGreenStrategy 存储蓝绿发布的配置
+// 给 ZwhDeployment 添加 apps.zwh.com/bluegreen-promote 注解时推广, 添加 apps.zwh.com/bluegreen-abort 注解时中止发布
+type BlueGreenStrategy struct {
+	//PreviewHost 访问 preview 版本的域名,只支持 ingress 模式.不填写时只能通过 <名称>-preview service 访问
+	//+optional
+	PreviewHost string `json:"previewHost,omitempty"`
+	//AutoPromote preview 就绪后自动推广,默认需要添加 promote 注解
+	//+optional
+	AutoPromote bool `json:"autoPromote,omitempty"`
+	//ScaleDownDelay 流量切换到新版本后,旧版本的 pod 保留的时间,在这段时间内可以中止发布快速回滚,默认为30s
+	//+optional
+	ScaleDownDelay *metav1.Duration `json:"scaleDownDelay,omitempty"`
+}
+
+// CanaryStrategy 存储金丝雀发布的配置
+// 给 ZwhDeployment 添加 apps.zwh.com/canary-promote 注解时结束当前步骤, 添加 apps.zwh.com/canary-abort 注解时中止发布
+type CanaryStrategy struct {
+	//Steps 发布步骤,按顺序执行,全部完成后把新镜像推广到稳定版本
+	//+kubebuilder:validation:MinItems=1
+	Steps []CanaryStep `json:"steps"`
+	//Replicas canary deployment 的副本数,默认为1
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	//ProgressDeadlineSeconds canary deployment 超过这个时间没有就绪时中止发布,默认为600
+	//+optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+}
+
+// CanaryStep 存储金丝雀发布的一个步骤
+type CanaryStep struct {
+	//Weight 转发到 canary 的流量百分比
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+	//Pause 在这一步停留的时间,例如 5m.不填写时 canary 就绪后直接进入下一步
+	//+optional
+	Pause *metav1.Duration `json:"pause,omitempty"`
+	//Manual 停在这一步,直到添加 apps.zwh.com/canary-promote 注解
+	//+optional
+	Manual bool `json:"manual,omitempty"`
+}
+
+// Config 存储由 operator 生成的 ConfigMap 的内容, data 和 files 的键不能重复
+type Config struct {
+	//Data 键值对,作为环境变量注入到主容器
+	//+optional
+	Data map[string]string `json:"data,omitempty"`
+	//Files 文件名和文件内容,挂载到主容器的 mountPath 目录
+	//+optional
+	Files map[string]string `json:"files,omitempty"`
+	//MountPath 文件的挂载目录,默认为 /etc/config
+	//+optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// RegistryCredentials 存储集中管理的镜像仓库凭证, secret 的类型需要是 kubernetes.io/dockerconfigjson 或 kubernetes.io/dockercfg
+type RegistryCredentials struct {
+	//Name 凭证的 secret 名称
+	Name string `json:"name"`
+}
+
+// SecretRef 存储引用的已有 secret, secret 需要和 ZwhDeployment 在同一个命名空间
+type SecretRef struct {
+	//Name secret 名称
+	Name string `json:"name"`
+	//MountPath 挂载目录,填写时以文件的方式挂载到主容器,未填写时所有的键作为环境变量注入
+	//+optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// ContainerPort 存储容器的一个命名端口, service 和 ingress 通过名称选择端口
+type ContainerPort struct {
+	//Name 端口名称,在容器中唯一
+	Name string `json:"name"`
+	//ContainerPort 容器端口
+	ContainerPort int32 `json:"containerPort"`
+	//Protocol 协议 TCP, UDP or SCTP,默认为 TCP
+	//+kubebuilder:validation:Enum=TCP;UDP;SCTP
+	//+optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+	//AppProtocol 应用层协议,例如 http 或 grpc,设置到 service 的端口上
+	//+optional
+	AppProtocol string `json:"appProtocol,omitempty"`
+	//Exposed 是否通过 service 暴露,默认为 true.例如管理端口只在容器内访问时可以设置为 false
+	//+optional
+	Exposed *bool `json:"exposed,omitempty"`
+	//NodePort 节点端口,在mode 为nodeport时有效,未填写时由集群分配.主端口未填写时使用 expose 中的 nodePort
+	//+optional
+	NodePort int32 `json:"nodePort,omitempty"`
+}
+
+// Storage 存储由 operator 管理的 pvc
+// workloadKind 为 StatefulSet 时,claims 会作为 volumeClaimTemplates 为每个 pod 单独创建 pvc
+type Storage struct {
+	//Claims 要创建的pvc
+	Claims []StorageClaim `json:"claims"`
+	//ReclaimPolicy ZwhDeployment 删除时 pvc 的处理方式 retain or delete,默认为 retain
+	//+kubebuilder:validation:Enum=retain;delete
+	//+optional
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+}
+
+// StorageClaim 存储单个 pvc 的配置
+type StorageClaim struct {
+	//Name 存储卷名称,创建的pvc名称为 <ZwhDeployment名称>-<name>
+	Name string `json:"name"`
+	//Size 存储大小,只能扩容
+	Size resource.Quantity `json:"size"`
+	//StorageClassName 存储类,不填写时使用集群默认的存储类
+	//+optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+	//AccessModes 访问模式,默认为 ReadWriteOnce
+	//+optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	//MountPath 容器中的挂载路径,填写后自动生成对应的 volumeMount
+	//+optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// Probes 存储容器的健康检查,直接使用pod中的定义方式,端口没有填写时使用ZwhDeploymentSpec的port值
+type Probes struct {
+	//Liveness 存活检查
+	//+optional
+	Liveness *corev1.Probe `json:"liveness,omitempty"`
+	//Readiness 就绪检查
+	//+optional
+	Readiness *corev1.Probe `json:"readiness,omitempty"`
+	//Startup 启动检查
+	//+optional
+	Startup *corev1.Probe `json:"startup,omitempty"`
+}
+
+// Autoscaling 存储 operator 管理的 autoscaling/v2 HorizontalPodAutoscaler 配置
+type Autoscaling struct {
+	//Enable 是否开启自动扩缩容
+	Enable bool `json:"enable"`
+	//MinReplicas 最小副本数,默认为1
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	//MaxReplicas 最大副本数
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	//TargetCPUUtilizationPercentage cpu 平均使用率目标,相对于 requests 的百分比
+	//+optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	//TargetMemoryUtilizationPercentage 内存平均使用率目标,相对于 requests 的百分比
+	//+optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+	//Metrics 自定义指标,直接使用hpa中的定义方式,和上面的cpu、内存目标一起生效
+	//+optional
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+	//Behavior 扩缩容行为,直接使用hpa中的定义方式
+	//+optional
+	Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
+
+// ServiceAccount 存储 pod 使用的 ServiceAccount, create 和 name 只能填写一个
+type ServiceAccount struct {
+	//Create 为true时由operator创建和 ZwhDeployment 同名的 ServiceAccount,删除 ZwhDeployment 时一起删除
+	//+optional
+	Create bool `json:"create,omitempty"`
+	//Name 使用已有的 ServiceAccount
+	//+optional
+	Name string `json:"name,omitempty"`
+	//AutomountToken 是否在 pod 中挂载 ServiceAccount 的 token
+	//未填写时,operator 创建的 ServiceAccount 只在填写了 rbac.rules 时挂载,已有的 ServiceAccount 使用它自己的配置
+	//+optional
+	AutomountToken *bool `json:"automountToken,omitempty"`
+}
+
+// RBAC 存储授予 ServiceAccount 的 namespace 内的权限
+// operator 拥有 roles 的 escalate 和 bind 权限, 可以创建任意 namespace 内的权限,
+// 能创建或修改 ZwhDeployment 的用户等同于能在这个 namespace 中创建 Role 和 RoleBinding
+type RBAC struct {
+	//Rules 直接使用 Role 中的定义方式,不支持 nonResourceURLs
+	//+optional
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// NetworkPolicy 存储 NetworkPolicy 的配置, 规则同时作用于金丝雀发布和蓝绿发布的 pod
+type NetworkPolicy struct {
+	//Ingress 允许访问的来源,只开放 ports 中 exposed 的端口.为空时不限制入口流量
+	//+optional
+	Ingress []NetworkPolicyPeer `json:"ingress,omitempty"`
+	//Egress 允许访问的目标,为空时不限制出口流量.填写后自动允许访问 kube-system 中的 DNS
+	//+optional
+	Egress []NetworkPolicyEgress `json:"egress,omitempty"`
+	//IngressControllerNamespace ingress controller 所在的 namespace,默认为 ingress-nginx
+	//+optional
+	IngressControllerNamespace string `json:"ingressControllerNamespace,omitempty"`
+}
+
+// NetworkPolicyPeer 存储一个来源或目标, zwhDeployment/namespace、cidr 和 ingressController 只能填写一种
+type NetworkPolicyPeer struct {
+	//ZwhDeployment ZwhDeployment 的名称,包括它的金丝雀和蓝绿发布的 pod.没有填写 namespace 时为同一个 namespace
+	//+optional
+	ZwhDeployment string `json:"zwhDeployment,omitempty"`
+	//Namespace namespace 的名称,没有填写 zwhDeployment 时为 namespace 中的所有 pod
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+	//CIDR 集群外的网段,例如 10.0.0.0/8
+	//+optional
+	CIDR string `json:"cidr,omitempty"`
+	//Except 在 cidr 中排除的网段
+	//+optional
+	Except []string `json:"except,omitempty"`
+	//IngressController 为true时表示 ingressControllerNamespace 中的 ingress controller
+	//+optional
+	IngressController bool `json:"ingressController,omitempty"`
+}
+
+// NetworkPolicyEgress 存储一个允许访问的目标和端口
+type NetworkPolicyEgress struct {
+	NetworkPolicyPeer `json:",inline"`
+	//Ports 允许访问的端口,为空时允许所有端口
+	//+optional
+	Ports []NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// NetworkPolicyPort 存储目标的端口
+type NetworkPolicyPort struct {
+	//Port 端口
+	Port int32 `json:"port"`
+	//Protocol 协议 TCP, UDP or SCTP,默认为 TCP
+	//+kubebuilder:validation:Enum=TCP;UDP;SCTP
+	//+optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+}
+
+// DisruptionBudget 存储 operator 管理的 PodDisruptionBudget 配置, minAvailable 和 maxUnavailable 只能填写一个
+type DisruptionBudget struct {
+	//Enable 是否生成pdb,默认为true,设置为false时不生成
+	//+optional
+	Enable *bool `json:"enable,omitempty"`
+	//MinAvailable 驱逐时至少保持可用的 pod 数量或百分比
+	//+optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+	//MaxUnavailable 驱逐时最多不可用的 pod 数量或百分比,都没有填写时默认为1
+	//+optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
 // Expose 存储服务暴露的端口
 type Expose struct {
-	//Mode 模式 nodeport or ingress
+	//Mode 模式 ingress, nodeport, loadbalancer, clusterip or gateway
+	//clusterip 只在集群内部访问,不创建ingress. gateway 使用 Gateway API 的 HTTPRoute 代替ingress
 	Mode string `json:"mode"`
 	//NodePort 节点端口	,在mode 为nodeport时，需要填写
 	NodePort int32 `json:"nodePort,omitempty"`
-	//IngressDomain 域名.在mode 为ingress时，需要填写
+	//IngressDomain 域名.在mode 为ingress时，和rules至少填写一个.只填写域名时把 / 转发到服务
 	//+optional
 	IngressDomain string `json:"ingressDomain,omitempty"`
-	//ServicePort service 端口,一般是随机生成,为了防止冲突，使用同上面ZwhDeploymentSpec的port值
+	//Rules ingress 的转发规则,可以配置多个域名和路径,填写后ingressDomain不再生成规则
+	//+optional
+	Rules []IngressRule `json:"rules,omitempty"`
+	//IngressClassName ingress 的实现类,默认为 nginx
+	//+optional
+	IngressClassName string `json:"ingressClassName,omitempty"`
+	//IngressAnnotations ingress 的注解,原样设置到生成的ingress上
+	//+optional
+	IngressAnnotations map[string]string `json:"ingressAnnotations,omitempty"`
+	//ServicePort service 端口,一般是随机生成,为了防止冲突，使用同上面ZwhDeploymentSpec的port值.未填写时默认为port
 	//+optional
 	ServicePort int32 `json:"servicePort,omitempty"`
+	//Tls https 配置,在mode 为ingress时有效
+	//+optional
+	Tls *Tls `json:"tls,omitempty"`
+	//LoadBalancerIP 指定负载均衡的ip,在mode 为loadbalancer时有效,需要负载均衡的实现支持
+	//+optional
+	LoadBalancerIP string `json:"loadBalancerIP,omitempty"`
+	//LoadBalancerSourceRanges 允许访问负载均衡的客户端网段,在mode 为loadbalancer时有效
+	//+optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+	//LoadBalancerClass 负载均衡的实现类,在mode 为loadbalancer时有效,创建后不能修改
+	//+optional
+	LoadBalancerClass string `json:"loadBalancerClass,omitempty"`
+	//ServiceAnnotations service 的注解,例如 metallb 的地址池 metallb.universe.tf/address-pool
+	//+optional
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+	//Gateway HTTPRoute 配置,在mode 为gateway时，需要填写
+	//+optional
+	Gateway *Gateway `json:"gateway,omitempty"`
+}
+
+// IngressRule 存储一条 ingress 转发规则
+type IngressRule struct {
+	//Host 域名
+	Host string `json:"host"`
+	//Path 路径,默认为 /
+	//+optional
+	Path string `json:"path,omitempty"`
+	//PathType 路径匹配方式 Prefix, Exact or ImplementationSpecific,默认为 Prefix
+	//+kubebuilder:validation:Enum=Prefix;Exact;ImplementationSpecific
+	//+optional
+	PathType string `json:"pathType,omitempty"`
+	//RewriteTarget 转发到服务前重写的路径,对应 nginx.ingress.kubernetes.io/rewrite-target 注解
+	//注解对整个ingress生效,所以填写了的规则需要使用相同的值,和没有填写的规则混用时放到单独的 <名称>-rewrite ingress 中
+	//+optional
+	RewriteTarget string `json:"rewriteTarget,omitempty"`
+	//PortName 转发到的端口名称,需要是ports中暴露的端口,默认为主端口
+	//+optional
+	PortName string `json:"portName,omitempty"`
+}
+
+// Gateway 存储 Gateway API 的 HTTPRoute 配置
+type Gateway struct {
+	//Name 要挂载的 Gateway 名称
+	Name string `json:"name"`
+	//Namespace Gateway 所在的命名空间,默认与ZwhDeployment相同
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+	//SectionName Gateway 中 listener 的名称,不填写时挂载到所有 listener
+	//+optional
+	SectionName string `json:"sectionName,omitempty"`
+	//Hostnames 路由匹配的域名,不填写时使用 ingressDomain
+	//+optional
+	Hostnames []string `json:"hostnames,omitempty"`
+	//Paths 路由匹配的路径,默认为前缀匹配 /
+	//+optional
+	Paths []GatewayPath `json:"paths,omitempty"`
+}
+
+// GatewayPath 存储 HTTPRoute 的路径匹配
+type GatewayPath struct {
+	//Type 匹配方式 PathPrefix, Exact or RegularExpression,默认为 PathPrefix
+	//+kubebuilder:validation:Enum=PathPrefix;Exact;RegularExpression
+	//+optional
+	Type string `json:"type,omitempty"`
+	//Value 匹配的路径,需要以 / 开头
+	Value string `json:"value"`
+}
+
+// Tls 存储 ingress 的 https 证书配置
+type Tls struct {
+	//Enable 是否开启 https
+	Enable bool `json:"enable"`
+	//Issuer 证书的签发方式 selfsigned, issuer, clusterissuer or secret,默认为 selfsigned
+	//+kubebuilder:validation:Enum=selfsigned;issuer;clusterissuer;secret
+	//+optional
+	Issuer string `json:"issuer,omitempty"`
+	//IssuerName 已存在的 Issuer/ClusterIssuer 名称,在issuer为issuer或clusterissuer时，需要填写
+	//+optional
+	IssuerName string `json:"issuerName,omitempty"`
+	//SecretName 证书存放的secret名称,默认与ZwhDeployment同名.在issuer为secret时，需要填写用户自己提供的secret
+	//+optional
+	SecretName string `json:"secretName,omitempty"`
 }
 
 // ZwhDeploymentStatus defines the observed state of ZwhDeployment
@@ -75,6 +540,107 @@ type ZwhDeploymentStatus struct {
 	Reason string `json:"reason,omitempty"`
 	// 这个阶段的子资源的状态
 	Conditions []Condition `json:"conditions,omitempty"`
+	// pod 中所有容器和初始化容器的资源所对应的 QoS 等级
+	QOSClass corev1.PodQOSClass `json:"qosClass,omitempty"`
+	// mode 为 loadbalancer 时, 负载均衡分配的外部地址
+	ExternalAddress string `json:"externalAddress,omitempty"`
+	// 开启自动扩缩容时, hpa 记录的当前副本数
+	CurrentReplicas int32 `json:"currentReplicas,omitempty"`
+	// 开启自动扩缩容时, hpa 计算出的期望副本数
+	DesiredReplicas int32 `json:"desiredReplicas,omitempty"`
+	// 金丝雀发布的进度
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// 蓝绿发布的进度
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+	// 当前 spec 对应的历史版本号
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+	// 最近一次滚动更新的结果和上一次成功发布的记录
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+	// imagePolicy 为 pinDigest 时解析出的镜像摘要, 第一个为 spec.image, 其他为发布中稳定版本仍在使用的镜像
+	Images []ImageDigest `json:"images,omitempty"`
+	// pod 模板当前使用的 configmap 和 secret 的内容哈希, 变化时滚动更新 pod
+	ConfigHash string `json:"configHash,omitempty"`
+	// 每个容器在所有 pod 中的重启次数和等待原因, 顺序为初始化容器、主容器、边车容器
+	Containers []ContainerStatus `json:"containers,omitempty"`
+	// 状态的变更版本,每次conditions或阶段发生变化时加1
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// ImageDigest 存储镜像的 tag 和解析出的摘要
+type ImageDigest struct {
+	//Image spec 中填写的镜像
+	Image string `json:"image"`
+	//Digest 镜像的摘要,例如 sha256:...
+	Digest string `json:"digest"`
+	//ResolvedTime 解析摘要的时间
+	//+optional
+	ResolvedTime *metav1.Time `json:"resolvedTime,omitempty"`
+}
+
+// RolloutStatus 存储 deployment 滚动更新的结果
+type RolloutStatus struct {
+	//阶段 Progressing, Complete, Failed or RolledBack
+	Phase string `json:"phase,omitempty"`
+	//发布失败的镜像
+	FailedImage string `json:"failedImage,omitempty"`
+	//发布失败时 ZwhDeployment 的 generation, spec 变化后重新发布
+	FailedGeneration int64 `json:"failedGeneration,omitempty"`
+	//发布失败的原因
+	Message string `json:"message,omitempty"`
+	//上一次成功发布的镜像
+	LastGoodImage string `json:"lastGoodImage,omitempty"`
+	//上一次成功发布时 ZwhDeployment 的 generation
+	LastGoodGeneration int64 `json:"lastGoodGeneration,omitempty"`
+	//上一次成功发布的 pod 模板,回滚时使用.不生成 schema, 避免 crd 过大
+	//+kubebuilder:validation:Schemaless
+	//+kubebuilder:validation:Type=object
+	//+kubebuilder:pruning:PreserveUnknownFields
+	LastGoodTemplate *corev1.PodTemplateSpec `json:"lastGoodTemplate,omitempty"`
+}
+
+// BlueGreenStatus 存储蓝绿发布的进度
+// 从 Switched 到 Promoted 之间, 主 service 的流量转发到 preview 颜色的 pod
+type BlueGreenStatus struct {
+	//阶段 Previewing, Paused, Switched, Promoting, Promoted or Aborted
+	Phase string `json:"phase,omitempty"`
+	//已经推广的版本的颜色, blue 或者 green
+	ActiveColor string `json:"activeColor,omitempty"`
+	//正在发布的版本的颜色,没有发布时为空
+	PreviewColor string `json:"previewColor,omitempty"`
+	//已经推广的版本的镜像
+	ActiveImage string `json:"activeImage,omitempty"`
+	//正在发布的版本的镜像
+	PreviewImage string `json:"previewImage,omitempty"`
+	//流量切换到 preview 的时间,用来计算 scaleDownDelay
+	SwitchTime *metav1.Time `json:"switchTime,omitempty"`
+	//最近一次阶段变化的原因,例如中止的原因
+	Message string `json:"message,omitempty"`
+}
+
+// CanaryStatus 存储金丝雀发布的进度
+type CanaryStatus struct {
+	//阶段 Progressing, Paused, Promoting, Promoted or Aborted
+	Phase string `json:"phase,omitempty"`
+	//当前执行到的步骤下标,从0开始
+	CurrentStep int32 `json:"currentStep"`
+	//稳定版本的镜像
+	StableImage string `json:"stableImage,omitempty"`
+	//canary 版本的镜像
+	CanaryImage string `json:"canaryImage,omitempty"`
+	//当前步骤开始的时间,用来计算暂停的时间
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+	//最近一次阶段变化的原因,例如中止的原因
+	Message string `json:"message,omitempty"`
+}
+
+// ContainerStatus 汇总一个容器在所有 pod 中的状态
+type ContainerStatus struct {
+	//容器名称
+	Name string `json:"name"`
+	//所有 pod 中这个容器的重启次数之和
+	RestartCount int32 `json:"restartCount"`
+	//容器没有运行时等待的原因,例如 CrashLoopBackOff, ImagePullBackOff
+	WaitingReason string `json:"waitingReason,omitempty"`
 }
 
 // Condition 子资源的状态
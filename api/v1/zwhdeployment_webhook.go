@@ -0,0 +1,941 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var zwhdeploymentlog = logf.Log.WithName("zwhdeployment-resource")
+
+// SetupWebhookWithManager will setup the manager to manage the webhooks
+func (r *ZwhDeployment) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-apps-zwh-com-v1-zwhdeployment,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps.zwh.com,resources=zwhdeployments,verbs=create;update,versions=v1,name=mzwhdeployment.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &ZwhDeployment{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+// 填充的默认值和 operator 渲染子资源时使用的一致,保证存储的对象就是实际生效的配置
+func (r *ZwhDeployment) Default() {
+	zwhdeploymentlog.Info("default", "name", r.Name)
+	r.setDefaults()
+}
+
+func (r *ZwhDeployment) setDefaults() {
+	if r.Spec.Replicas == 0 {
+		r.Spec.Replicas = 1
+	}
+	if r.Spec.WorkloadKind == "" {
+		r.Spec.WorkloadKind = WorkloadKindDeployment
+	}
+	r.Spec.Size = strings.ToLower(r.Spec.Size)
+	if r.Spec.Port == 0 && len(r.Spec.Ports) > 0 {
+		r.Spec.Port = r.Spec.Ports[0].ContainerPort
+	}
+	for i := range r.Spec.Ports {
+		if r.Spec.Ports[i].Protocol == "" {
+			r.Spec.Ports[i].Protocol = corev1.ProtocolTCP
+		}
+	}
+
+	if r.Spec.Expose != nil {
+		r.Spec.Expose.Mode = strings.ToLower(r.Spec.Expose.Mode)
+		if r.Spec.Expose.ServicePort == 0 {
+			r.Spec.Expose.ServicePort = r.Spec.Port
+		}
+		if r.Spec.Expose.Mode == ModeIngress && r.Spec.Expose.IngressClassName == "" {
+			r.Spec.Expose.IngressClassName = "nginx"
+		}
+		for i := range r.Spec.Expose.Rules {
+			if r.Spec.Expose.Rules[i].Path == "" {
+				r.Spec.Expose.Rules[i].Path = "/"
+			}
+			if r.Spec.Expose.Rules[i].PathType == "" {
+				r.Spec.Expose.Rules[i].PathType = "Prefix"
+			}
+		}
+		if gateway := r.Spec.Expose.Gateway; gateway != nil {
+			for i := range gateway.Paths {
+				if gateway.Paths[i].Type == "" {
+					gateway.Paths[i].Type = GatewayPathPrefix
+				}
+			}
+		}
+		if tls := r.Spec.Expose.Tls; tls != nil && tls.Enable {
+			tls.Issuer = strings.ToLower(tls.Issuer)
+			if tls.Issuer == "" {
+				tls.Issuer = TlsIssuerSelfSigned
+			}
+			// secret 方式必须由用户提供, 不能默认
+			if tls.SecretName == "" && tls.Issuer != TlsIssuerSecret {
+				tls.SecretName = r.Name
+			}
+		}
+	}
+
+	if r.Spec.Config != nil && len(r.Spec.Config.Files) > 0 && r.Spec.Config.MountPath == "" {
+		r.Spec.Config.MountPath = "/etc/config"
+	}
+
+	if r.Spec.RevisionHistoryLimit == nil {
+		limit := int32(10)
+		r.Spec.RevisionHistoryLimit = &limit
+	}
+	if r.Spec.Strategy != nil && r.Spec.Strategy.Canary != nil && r.Spec.Strategy.Canary.Replicas == nil {
+		replicas := int32(1)
+		r.Spec.Strategy.Canary.Replicas = &replicas
+	}
+	if r.Spec.Strategy != nil && r.Spec.Strategy.BlueGreen != nil && r.Spec.Strategy.BlueGreen.ScaleDownDelay == nil {
+		r.Spec.Strategy.BlueGreen.ScaleDownDelay = &metav1.Duration{Duration: 30 * time.Second}
+	}
+
+	if r.Spec.NetworkPolicy != nil && r.Spec.NetworkPolicy.IngressControllerNamespace == "" {
+		r.Spec.NetworkPolicy.IngressControllerNamespace = DefaultIngressControllerNamespace
+	}
+
+	if r.Spec.Autoscaling != nil && r.Spec.Autoscaling.Enable && r.Spec.Autoscaling.MinReplicas == nil {
+		minReplicas := int32(1)
+		r.Spec.Autoscaling.MinReplicas = &minReplicas
+	}
+
+	if r.Spec.Storage != nil {
+		r.Spec.Storage.ReclaimPolicy = strings.ToLower(r.Spec.Storage.ReclaimPolicy)
+		if r.Spec.Storage.ReclaimPolicy == "" {
+			r.Spec.Storage.ReclaimPolicy = StorageReclaimRetain
+		}
+		for i := range r.Spec.Storage.Claims {
+			if len(r.Spec.Storage.Claims[i].AccessModes) == 0 {
+				r.Spec.Storage.Claims[i].AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+			}
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-apps-zwh-com-v1-zwhdeployment,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.zwh.com,resources=zwhdeployments,verbs=create;update,versions=v1,name=vzwhdeployment.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &ZwhDeployment{}
+
+// nodePort 的默认范围, 与 apiserver 的 --service-node-port-range 默认值一致
+const (
+	nodePortMin = 30000
+	nodePortMax = 32767
+)
+
+// imageReferenceRegexp 镜像地址的格式 [registry[:port]/]name[:tag][@digest]
+var imageReferenceRegexp = regexp.MustCompile(`^` +
+	`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?` +
+	`$`)
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ZwhDeployment) ValidateCreate() (admission.Warnings, error) {
+	zwhdeploymentlog.Info("validate create", "name", r.Name)
+
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateAnnotations()...)
+	return r.warnings(), r.toInvalid(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ZwhDeployment) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	zwhdeploymentlog.Info("validate update", "name", r.Name)
+
+	oldMd, ok := old.(*ZwhDeployment)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a ZwhDeployment but got a %T", old))
+	}
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateAnnotations()...)
+	allErrs = append(allErrs, r.validateImmutable(oldMd)...)
+	return r.warnings(), r.toInvalid(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ZwhDeployment) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateSpec 校验 spec 中各个字段的取值
+func (r *ZwhDeployment) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if !imageReferenceRegexp.MatchString(r.Spec.Image) || len(r.Spec.Image) > 255 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("image"), r.Spec.Image,
+			"must be a valid image reference, e.g. registry.example.com/app:v1"))
+	}
+	allErrs = append(allErrs, r.validateRegistry(specPath)...)
+	allErrs = append(allErrs, r.validatePorts(specPath)...)
+	allErrs = append(allErrs, apivalidation.ValidateNonnegativeField(int64(r.Spec.Replicas), specPath.Child("replicas"))...)
+	allErrs = append(allErrs, r.validateExpose(specPath.Child("expose"))...)
+	allErrs = append(allErrs, r.validateStorage(specPath.Child("storage"))...)
+	allErrs = append(allErrs, r.validateContainers(specPath)...)
+	allErrs = append(allErrs, r.validateConfig(specPath)...)
+	allErrs = append(allErrs, r.validateScheduling(specPath)...)
+	allErrs = append(allErrs, r.validateServiceAccount(specPath)...)
+	allErrs = append(allErrs, r.validateNetworkPolicy(specPath.Child("networkPolicy"))...)
+	allErrs = append(allErrs, r.validateStrategy(specPath.Child("strategy"))...)
+	allErrs = append(allErrs, r.validateAutoscaling(specPath.Child("autoscaling"))...)
+	allErrs = append(allErrs, r.validateDisruptionBudget(specPath.Child("disruptionBudget"))...)
+	return allErrs
+}
+
+// validateAnnotations 校验 operator 处理的注解, 回滚的版本号需要是正整数
+func (r *ZwhDeployment) validateAnnotations() field.ErrorList {
+	var allErrs field.ErrorList
+	if value, ok := r.Annotations[AnnotationRollbackTo]; ok {
+		if revision, err := strconv.ParseInt(value, 10, 64); err != nil || revision < 1 {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "annotations").Key(AnnotationRollbackTo),
+				value, "must be a positive revision number"))
+		}
+	}
+	return allErrs
+}
+
+// validateRegistry 校验 imagePullSecrets 和 registryCredentials 引用的 secret 名称
+func (r *ZwhDeployment) validateRegistry(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, secret := range r.Spec.ImagePullSecrets {
+		idxPath := specPath.Child("imagePullSecrets").Index(i).Child("name")
+		if secret.Name == "" {
+			allErrs = append(allErrs, field.Required(idxPath, "can not be empty"))
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(secret.Name) {
+			allErrs = append(allErrs, field.Invalid(idxPath, secret.Name, msg))
+		}
+	}
+	if ref := r.Spec.RegistryCredentials; ref != nil {
+		credPath := specPath.Child("registryCredentials")
+		for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+			allErrs = append(allErrs, field.Invalid(credPath.Child("name"), ref.Name, msg))
+		}
+	}
+	return allErrs
+}
+
+// validatePorts 校验 port 和 ports, 填写了 ports 时 port 需要是其中一个端口的 containerPort
+func (r *ZwhDeployment) validatePorts(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(r.Spec.Ports) == 0 {
+		for _, msg := range validation.IsValidPortNum(int(r.Spec.Port)) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("port"), r.Spec.Port, msg))
+		}
+		return allErrs
+	}
+	portsPath := specPath.Child("ports")
+	names := map[string]bool{}
+	containerPorts := map[string]bool{}
+	for i, port := range r.Spec.Ports {
+		idxPath := portsPath.Index(i)
+		for _, msg := range validation.IsValidPortName(port.Name) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), port.Name, msg))
+		}
+		if names[port.Name] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), port.Name))
+		}
+		names[port.Name] = true
+		for _, msg := range validation.IsValidPortNum(int(port.ContainerPort)) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("containerPort"), port.ContainerPort, msg))
+		}
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		// 相同的端口可以同时使用 TCP 和 UDP, 例如 dns
+		key := fmt.Sprintf("%d/%s", port.ContainerPort, protocol)
+		if containerPorts[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("containerPort"), port.ContainerPort))
+		}
+		containerPorts[key] = true
+		if port.NodePort != 0 {
+			for _, msg := range validation.IsInRange(int(port.NodePort), nodePortMin, nodePortMax) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("nodePort"), port.NodePort, msg))
+			}
+		}
+	}
+	primary := r.primaryPortIndex()
+	if r.Spec.Port != 0 && r.Spec.Ports[primary].ContainerPort != r.Spec.Port {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("port"), r.Spec.Port, "must be the containerPort of one of spec.ports"))
+	} else if exposed := r.Spec.Ports[primary].Exposed; exposed != nil && !*exposed {
+		allErrs = append(allErrs, field.Invalid(portsPath.Index(primary).Child("exposed"), false,
+			"the primary port is the backend of the ingress and gateway, it must be exposed"))
+	}
+	return allErrs
+}
+
+// primaryPortIndex 获取主端口在 ports 中的下标, port 对应的端口或者第一个端口
+func (r *ZwhDeployment) primaryPortIndex() int {
+	for i, port := range r.Spec.Ports {
+		if port.ContainerPort == r.Spec.Port {
+			return i
+		}
+	}
+	return 0
+}
+
+// exposedPortNames 获取通过 service 暴露的端口名称, ingress 规则只能转发到这些端口
+func (r *ZwhDeployment) exposedPortNames() map[string]bool {
+	if len(r.Spec.Ports) == 0 {
+		return map[string]bool{DefaultPortName: true}
+	}
+	names := map[string]bool{}
+	for _, port := range r.Spec.Ports {
+		if port.Exposed == nil || *port.Exposed {
+			names[port.Name] = true
+		}
+	}
+	return names
+}
+
+func (r *ZwhDeployment) validateExpose(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	expose := r.Spec.Expose
+	if expose == nil {
+		return append(allErrs, field.Required(fldPath, ""))
+	}
+	switch strings.ToLower(expose.Mode) {
+	case ModeNodePort:
+		if expose.NodePort == 0 && (len(r.Spec.Ports) == 0 || r.Spec.Ports[r.primaryPortIndex()].NodePort == 0) {
+			allErrs = append(allErrs, field.Required(fldPath.Child("nodePort"), "required when mode is nodeport, unless the primary port in spec.ports has a nodePort"))
+		} else if expose.NodePort != 0 {
+			for _, msg := range validation.IsInRange(int(expose.NodePort), nodePortMin, nodePortMax) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("nodePort"), expose.NodePort, msg))
+			}
+		}
+	case ModeIngress:
+		if expose.IngressDomain == "" && len(expose.Rules) == 0 {
+			allErrs = append(allErrs, field.Required(fldPath.Child("ingressDomain"), "ingressDomain or rules is required when mode is ingress"))
+		} else if expose.IngressDomain != "" {
+			allErrs = append(allErrs, validateDomain(expose.IngressDomain, fldPath.Child("ingressDomain"))...)
+		}
+		allErrs = append(allErrs, validateIngressRules(expose.Rules, r.exposedPortNames(), fldPath.Child("rules"))...)
+		allErrs = append(allErrs, validateTls(expose.Tls, fldPath.Child("tls"))...)
+	case ModeLoadBalancer:
+		if expose.LoadBalancerIP != "" && net.ParseIP(expose.LoadBalancerIP) == nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("loadBalancerIP"), expose.LoadBalancerIP, "must be a valid IP address"))
+		}
+		for i, cidr := range expose.LoadBalancerSourceRanges {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("loadBalancerSourceRanges").Index(i), cidr, "must be a valid CIDR, e.g. 10.0.0.0/8"))
+			}
+		}
+	case ModeGateway:
+		allErrs = append(allErrs, validateGateway(expose.Gateway, fldPath.Child("gateway"))...)
+		if expose.IngressDomain != "" {
+			allErrs = append(allErrs, validateDomain(expose.IngressDomain, fldPath.Child("ingressDomain"))...)
+		}
+	case ModeClusterIP:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("mode"), expose.Mode,
+			[]string{ModeIngress, ModeNodePort, ModeLoadBalancer, ModeClusterIP, ModeGateway}))
+	}
+	if expose.ServicePort != 0 {
+		for _, msg := range validation.IsValidPortNum(int(expose.ServicePort)) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("servicePort"), expose.ServicePort, msg))
+		}
+	}
+	return allErrs
+}
+
+func validateTls(tls *Tls, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if tls == nil || !tls.Enable {
+		return allErrs
+	}
+	switch strings.ToLower(tls.Issuer) {
+	case TlsIssuerIssuer, TlsIssuerClusterIssuer:
+		if tls.IssuerName == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("issuerName"),
+				fmt.Sprintf("required when issuer is %s", tls.Issuer)))
+		}
+	case TlsIssuerSecret:
+		if tls.SecretName == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("secretName"), "required when issuer is secret"))
+		}
+	}
+	return allErrs
+}
+
+func validateIngressRules(rules []IngressRule, portNames map[string]bool, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	rewriteTarget := ""
+	for i, rule := range rules {
+		rulePath := fldPath.Index(i)
+		allErrs = append(allErrs, validateDomain(rule.Host, rulePath.Child("host"))...)
+		if rule.Path != "" && !strings.HasPrefix(rule.Path, "/") {
+			allErrs = append(allErrs, field.Invalid(rulePath.Child("path"), rule.Path, "must begin with '/'"))
+		}
+		if rule.PortName != "" && !portNames[rule.PortName] {
+			allErrs = append(allErrs, field.Invalid(rulePath.Child("portName"), rule.PortName, "must be the name of an exposed port in spec.ports"))
+		}
+		// rewrite-target 注解对整个 ingress 生效, 不同的值无法同时满足
+		if rule.RewriteTarget == "" {
+			continue
+		}
+		if rewriteTarget != "" && rule.RewriteTarget != rewriteTarget {
+			allErrs = append(allErrs, field.Invalid(rulePath.Child("rewriteTarget"), rule.RewriteTarget,
+				fmt.Sprintf("must be the same as the other rules (%s), the annotation applies to the whole ingress", rewriteTarget)))
+		}
+		rewriteTarget = rule.RewriteTarget
+	}
+	return allErrs
+}
+
+func validateGateway(gateway *Gateway, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if gateway == nil {
+		return append(allErrs, field.Required(fldPath, "required when mode is gateway"))
+	}
+	if gateway.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	}
+	for i, hostname := range gateway.Hostnames {
+		allErrs = append(allErrs, validateDomain(hostname, fldPath.Child("hostnames").Index(i))...)
+	}
+	for i, path := range gateway.Paths {
+		if !strings.HasPrefix(path.Value, "/") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("paths").Index(i).Child("value"), path.Value, "must begin with '/'"))
+		}
+	}
+	return allErrs
+}
+
+// validateDomain 域名需要符合 DNS-1123 规范, 允许使用 *.example.com 形式的泛域名
+func validateDomain(domain string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	msgs := validation.IsDNS1123Subdomain(domain)
+	if strings.HasPrefix(domain, "*.") {
+		msgs = validation.IsWildcardDNS1123Subdomain(domain)
+	}
+	for _, msg := range msgs {
+		allErrs = append(allErrs, field.Invalid(fldPath, domain, msg))
+	}
+	return allErrs
+}
+
+func (r *ZwhDeployment) validateStorage(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if r.Spec.Storage == nil {
+		return allErrs
+	}
+	names := map[string]bool{}
+	for i, claim := range r.Spec.Storage.Claims {
+		claimPath := fldPath.Child("claims").Index(i)
+		for _, msg := range validation.IsDNS1123Label(claim.Name) {
+			allErrs = append(allErrs, field.Invalid(claimPath.Child("name"), claim.Name, msg))
+		}
+		if names[claim.Name] {
+			allErrs = append(allErrs, field.Duplicate(claimPath.Child("name"), claim.Name))
+		}
+		names[claim.Name] = true
+		if claim.Size.Sign() <= 0 {
+			allErrs = append(allErrs, field.Invalid(claimPath.Child("size"), claim.Size.String(), "must be greater than zero"))
+		}
+	}
+	return allErrs
+}
+
+// validateContainers 校验边车和初始化容器, 容器名称在 pod 中唯一, 挂载的存储卷需要在 volumes 或 storage 中
+func (r *ZwhDeployment) validateContainers(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	// 主容器使用 ZwhDeployment 的名称
+	names := map[string]bool{r.Name: true}
+	volumes := map[string]bool{}
+	for _, volume := range r.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	if r.Spec.Storage != nil {
+		for _, claim := range r.Spec.Storage.Claims {
+			volumes[claim.Name] = true
+		}
+	}
+	validate := func(containers []corev1.Container, fldPath *field.Path) {
+		for i, container := range containers {
+			idxPath := fldPath.Index(i)
+			for _, msg := range validation.IsDNS1123Label(container.Name) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), container.Name, msg))
+			}
+			if names[container.Name] {
+				allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), container.Name))
+			}
+			names[container.Name] = true
+			if container.Image == "" {
+				allErrs = append(allErrs, field.Required(idxPath.Child("image"), ""))
+			}
+			for j, mount := range container.VolumeMounts {
+				if !volumes[mount.Name] {
+					allErrs = append(allErrs, field.NotFound(idxPath.Child("volumeMounts").Index(j).Child("name"), mount.Name))
+				}
+			}
+		}
+	}
+	validate(r.Spec.InitContainers, specPath.Child("initContainers"))
+	validate(r.Spec.Sidecars, specPath.Child("sidecars"))
+	return allErrs
+}
+
+// validateConfig 校验 config 和 secretRefs
+// data 作为环境变量注入, 键需要是合法的环境变量名; data 和 files 在同一个 configmap 中, 键不能重复
+func (r *ZwhDeployment) validateConfig(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if config := r.Spec.Config; config != nil {
+		configPath := specPath.Child("config")
+		for key := range config.Data {
+			keyPath := configPath.Child("data").Key(key)
+			for _, msg := range validation.IsEnvVarName(key) {
+				allErrs = append(allErrs, field.Invalid(keyPath, key, msg))
+			}
+			for _, msg := range validation.IsConfigMapKey(key) {
+				allErrs = append(allErrs, field.Invalid(keyPath, key, msg))
+			}
+		}
+		for key := range config.Files {
+			keyPath := configPath.Child("files").Key(key)
+			for _, msg := range validation.IsConfigMapKey(key) {
+				allErrs = append(allErrs, field.Invalid(keyPath, key, msg))
+			}
+			if _, ok := config.Data[key]; ok {
+				allErrs = append(allErrs, field.Duplicate(keyPath, key))
+			}
+		}
+		if config.MountPath != "" && !strings.HasPrefix(config.MountPath, "/") {
+			allErrs = append(allErrs, field.Invalid(configPath.Child("mountPath"), config.MountPath, "must be an absolute path"))
+		}
+	}
+	for i, ref := range r.Spec.SecretRefs {
+		refPath := specPath.Child("secretRefs").Index(i)
+		for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+			allErrs = append(allErrs, field.Invalid(refPath.Child("name"), ref.Name, msg))
+		}
+		if ref.MountPath != "" && !strings.HasPrefix(ref.MountPath, "/") {
+			allErrs = append(allErrs, field.Invalid(refPath.Child("mountPath"), ref.MountPath, "must be an absolute path"))
+		}
+	}
+	return allErrs
+}
+
+// validateScheduling 校验调度配置, 和 apiserver 对 pod 的校验保持一致, 避免创建 deployment 时才失败
+func (r *ZwhDeployment) validateScheduling(specPath *field.Path) field.ErrorList {
+	allErrs := metav1validation.ValidateLabels(r.Spec.NodeSelector, specPath.Child("nodeSelector"))
+	for i, toleration := range r.Spec.Tolerations {
+		idxPath := specPath.Child("tolerations").Index(i)
+		switch toleration.Operator {
+		case corev1.TolerationOpExists:
+			if toleration.Value != "" {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("value"), toleration.Value, "must be empty when operator is Exists"))
+			}
+		case "", corev1.TolerationOpEqual:
+			if toleration.Key == "" {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("operator"), toleration.Operator, "must be Exists when key is empty"))
+			}
+		default:
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("operator"), toleration.Operator,
+				[]string{string(corev1.TolerationOpEqual), string(corev1.TolerationOpExists)}))
+		}
+	}
+	seen := map[string]bool{}
+	for i, constraint := range r.Spec.TopologySpreadConstraints {
+		idxPath := specPath.Child("topologySpreadConstraints").Index(i)
+		if constraint.MaxSkew < 1 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("maxSkew"), constraint.MaxSkew, "must be greater than zero"))
+		}
+		if constraint.TopologyKey == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("topologyKey"), "can not be empty"))
+		}
+		switch constraint.WhenUnsatisfiable {
+		case corev1.DoNotSchedule, corev1.ScheduleAnyway:
+		default:
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("whenUnsatisfiable"), constraint.WhenUnsatisfiable,
+				[]string{string(corev1.DoNotSchedule), string(corev1.ScheduleAnyway)}))
+		}
+		// 相同的拓扑域和处理方式只能有一个
+		key := fmt.Sprintf("%s/%s", constraint.TopologyKey, constraint.WhenUnsatisfiable)
+		if seen[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath, key))
+		}
+		seen[key] = true
+	}
+	if name := r.Spec.PriorityClassName; name != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("priorityClassName"), name, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateServiceAccount 校验 serviceAccount 和 rbac
+// create 和 name 只能填写一个, rbac 的权限需要授予给一个 ServiceAccount
+func (r *ZwhDeployment) validateServiceAccount(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	saPath := specPath.Child("serviceAccount")
+	if sa := r.Spec.ServiceAccount; sa != nil {
+		switch {
+		case sa.Create && sa.Name != "":
+			allErrs = append(allErrs, field.Forbidden(saPath.Child("name"), "can not be set when create is true"))
+		case !sa.Create && sa.Name == "":
+			allErrs = append(allErrs, field.Required(saPath.Child("name"), "must be set when create is false"))
+		case sa.Name != "":
+			for _, msg := range validation.IsDNS1123Subdomain(sa.Name) {
+				allErrs = append(allErrs, field.Invalid(saPath.Child("name"), sa.Name, msg))
+			}
+		}
+	}
+	if r.Spec.RBAC == nil {
+		return allErrs
+	}
+	rbacPath := specPath.Child("rbac")
+	if r.Spec.ServiceAccount == nil && len(r.Spec.RBAC.Rules) > 0 {
+		allErrs = append(allErrs, field.Required(saPath, "must be set when rbac.rules is set"))
+	}
+	for i, rule := range r.Spec.RBAC.Rules {
+		idxPath := rbacPath.Child("rules").Index(i)
+		if len(rule.Verbs) == 0 {
+			allErrs = append(allErrs, field.Required(idxPath.Child("verbs"), "can not be empty"))
+		}
+		if len(rule.NonResourceURLs) > 0 {
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("nonResourceURLs"), "is not supported by a namespaced Role"))
+		}
+		if len(rule.APIGroups) == 0 {
+			allErrs = append(allErrs, field.Required(idxPath.Child("apiGroups"), "can not be empty, use \"\" for the core group"))
+		}
+		if len(rule.Resources) == 0 {
+			allErrs = append(allErrs, field.Required(idxPath.Child("resources"), "can not be empty"))
+		}
+	}
+	return allErrs
+}
+
+// validateNetworkPolicy 校验 networkPolicy 的来源和目标
+func (r *ZwhDeployment) validateNetworkPolicy(npPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	policy := r.Spec.NetworkPolicy
+	if policy == nil {
+		return allErrs
+	}
+	for i, peer := range policy.Ingress {
+		allErrs = append(allErrs, validateNetworkPolicyPeer(peer, npPath.Child("ingress").Index(i))...)
+	}
+	for i, egress := range policy.Egress {
+		idxPath := npPath.Child("egress").Index(i)
+		allErrs = append(allErrs, validateNetworkPolicyPeer(egress.NetworkPolicyPeer, idxPath)...)
+		for j, port := range egress.Ports {
+			for _, msg := range validation.IsValidPortNum(int(port.Port)) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("ports").Index(j).Child("port"), port.Port, msg))
+			}
+		}
+	}
+	if ns := policy.IngressControllerNamespace; ns != "" {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			allErrs = append(allErrs, field.Invalid(npPath.Child("ingressControllerNamespace"), ns, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateNetworkPolicyPeer zwhDeployment/namespace、cidr 和 ingressController 只能填写一种
+func validateNetworkPolicyPeer(peer NetworkPolicyPeer, peerPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	kinds := 0
+	if peer.ZwhDeployment != "" || peer.Namespace != "" {
+		kinds++
+	}
+	if peer.CIDR != "" {
+		kinds++
+	}
+	if peer.IngressController {
+		kinds++
+	}
+	if kinds != 1 {
+		return append(allErrs, field.Invalid(peerPath, peer,
+			"exactly one of zwhDeployment/namespace, cidr or ingressController must be set"))
+	}
+	if peer.ZwhDeployment != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(peer.ZwhDeployment) {
+			allErrs = append(allErrs, field.Invalid(peerPath.Child("zwhDeployment"), peer.ZwhDeployment, msg))
+		}
+	}
+	if peer.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(peer.Namespace) {
+			allErrs = append(allErrs, field.Invalid(peerPath.Child("namespace"), peer.Namespace, msg))
+		}
+	}
+	if peer.CIDR == "" {
+		if len(peer.Except) > 0 {
+			allErrs = append(allErrs, field.Forbidden(peerPath.Child("except"), "can only be set with cidr"))
+		}
+		return allErrs
+	}
+	_, network, err := net.ParseCIDR(peer.CIDR)
+	if err != nil {
+		return append(allErrs, field.Invalid(peerPath.Child("cidr"), peer.CIDR, "must be a valid CIDR, e.g. 10.0.0.0/8"))
+	}
+	// except 需要在 cidr 的范围内
+	for i, except := range peer.Except {
+		ip, _, err := net.ParseCIDR(except)
+		if err != nil || !network.Contains(ip) {
+			allErrs = append(allErrs, field.Invalid(peerPath.Child("except").Index(i), except, "must be a valid CIDR within cidr"))
+		}
+	}
+	return allErrs
+}
+
+// validateStrategy 金丝雀发布依赖 deployment 和 ingress-nginx 的 canary 注解, 蓝绿发布依赖 deployment
+func (r *ZwhDeployment) validateStrategy(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if r.Spec.Strategy == nil {
+		return allErrs
+	}
+	if r.Spec.Strategy.BlueGreen != nil {
+		if r.Spec.Strategy.Canary != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("blueGreen"), "canary and blueGreen cannot be both set"))
+		}
+		allErrs = append(allErrs, r.validateBlueGreen(fldPath.Child("blueGreen"))...)
+	}
+	if r.Spec.Strategy.Canary == nil {
+		return allErrs
+	}
+	canary := r.Spec.Strategy.Canary
+	canaryPath := fldPath.Child("canary")
+	if r.Spec.WorkloadKind != "" && r.Spec.WorkloadKind != WorkloadKindDeployment {
+		allErrs = append(allErrs, field.Forbidden(canaryPath, "canary is only supported when workloadKind is Deployment"))
+	}
+	if r.Spec.Expose == nil || strings.ToLower(r.Spec.Expose.Mode) != ModeIngress {
+		allErrs = append(allErrs, field.Forbidden(canaryPath, "canary is only supported in ingress mode"))
+	}
+	if len(canary.Steps) == 0 {
+		allErrs = append(allErrs, field.Required(canaryPath.Child("steps"), "at least one step is required"))
+	}
+	for i, step := range canary.Steps {
+		stepPath := canaryPath.Child("steps").Index(i)
+		if step.Weight < 0 || step.Weight > 100 {
+			allErrs = append(allErrs, field.Invalid(stepPath.Child("weight"), step.Weight, "must be between 0 and 100"))
+		}
+		if step.Pause != nil && step.Pause.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(stepPath.Child("pause"), step.Pause.Duration.String(), "must not be negative"))
+		}
+	}
+	if canary.Replicas != nil && *canary.Replicas < 1 {
+		allErrs = append(allErrs, field.Invalid(canaryPath.Child("replicas"), *canary.Replicas, "must be greater than or equal to 1"))
+	}
+	if deadline := canary.ProgressDeadlineSeconds; deadline != nil && *deadline <= 0 {
+		allErrs = append(allErrs, field.Invalid(canaryPath.Child("progressDeadlineSeconds"), *deadline, "must be greater than zero"))
+	}
+	return allErrs
+}
+
+func (r *ZwhDeployment) validateBlueGreen(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	blueGreen := r.Spec.Strategy.BlueGreen
+	if r.Spec.WorkloadKind != "" && r.Spec.WorkloadKind != WorkloadKindDeployment {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "blueGreen is only supported when workloadKind is Deployment"))
+	}
+	if host := blueGreen.PreviewHost; host != "" {
+		hostPath := fldPath.Child("previewHost")
+		if r.Spec.Expose == nil || strings.ToLower(r.Spec.Expose.Mode) != ModeIngress {
+			allErrs = append(allErrs, field.Forbidden(hostPath, "previewHost is only supported in ingress mode"))
+		} else if host == r.Spec.Expose.IngressDomain {
+			allErrs = append(allErrs, field.Invalid(hostPath, host, "must be different from ingressDomain"))
+		}
+		allErrs = append(allErrs, validateDomain(host, hostPath)...)
+	}
+	if delay := blueGreen.ScaleDownDelay; delay != nil && delay.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("scaleDownDelay"), delay.Duration.String(), "must not be negative"))
+	}
+	return allErrs
+}
+
+func (r *ZwhDeployment) validateAutoscaling(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	autoscaling := r.Spec.Autoscaling
+	if autoscaling == nil || !autoscaling.Enable {
+		return allErrs
+	}
+	if autoscaling.MaxReplicas < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxReplicas"), autoscaling.MaxReplicas, "must be greater than or equal to 1"))
+	}
+	if autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("minReplicas"), *autoscaling.MinReplicas, "must be less than or equal to maxReplicas"))
+	}
+	if cpu := autoscaling.TargetCPUUtilizationPercentage; cpu != nil && *cpu <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("targetCPUUtilizationPercentage"), *cpu, "must be greater than zero"))
+	}
+	if memory := autoscaling.TargetMemoryUtilizationPercentage; memory != nil && *memory <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("targetMemoryUtilizationPercentage"), *memory, "must be greater than zero"))
+	}
+	return allErrs
+}
+
+func (r *ZwhDeployment) validateDisruptionBudget(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	budget := r.Spec.DisruptionBudget
+	if budget == nil {
+		return allErrs
+	}
+	if budget.MinAvailable != nil && budget.MaxUnavailable != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("maxUnavailable"), "minAvailable and maxUnavailable cannot be both set"))
+	}
+	allErrs = append(allErrs, validateIntOrPercent(budget.MinAvailable, fldPath.Child("minAvailable"))...)
+	allErrs = append(allErrs, validateIntOrPercent(budget.MaxUnavailable, fldPath.Child("maxUnavailable"))...)
+	return allErrs
+}
+
+// validateIntOrPercent 数量不能为负数, 百分比需要是 0%-100%
+func validateIntOrPercent(value *intstr.IntOrString, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if value == nil {
+		return allErrs
+	}
+	if value.Type == intstr.Int {
+		return append(allErrs, apivalidation.ValidateNonnegativeField(int64(value.IntValue()), fldPath)...)
+	}
+	percent, err := intstr.GetScaledValueFromIntOrPercent(value, 100, true)
+	if err != nil || percent < 0 || percent > 100 {
+		allErrs = append(allErrs, field.Invalid(fldPath, value.String(), "must be an integer or a percentage between 0% and 100%"))
+	}
+	return allErrs
+}
+
+// validateImmutable 校验创建后不能修改的字段
+func (r *ZwhDeployment) validateImmutable(old *ZwhDeployment) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	// 旧对象可能是在 webhook 启用前创建的, 两边都补齐默认值再比较
+	cur, old := r.DeepCopy(), old.DeepCopy()
+	cur.setDefaults()
+	old.setDefaults()
+
+	// 切换工作负载类型会删除所有的 pod 和 pvc
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(
+		cur.Spec.WorkloadKind, old.Spec.WorkloadKind, specPath.Child("workloadKind"))...)
+
+	// loadBalancerClass 在 service 创建后不能修改
+	if cur.Spec.Expose != nil && old.Spec.Expose != nil &&
+		cur.Spec.Expose.Mode == ModeLoadBalancer && old.Spec.Expose.Mode == ModeLoadBalancer {
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(
+			cur.Spec.Expose.LoadBalancerClass, old.Spec.Expose.LoadBalancerClass, specPath.Child("expose", "loadBalancerClass"))...)
+	}
+
+	if cur.Spec.Storage == nil || old.Spec.Storage == nil {
+		return allErrs
+	}
+	claimsPath := specPath.Child("storage", "claims")
+	// statefulset 的 volumeClaimTemplates 创建后不能修改
+	if cur.Spec.WorkloadKind == WorkloadKindStatefulSet {
+		return append(allErrs, apivalidation.ValidateImmutableField(cur.Spec.Storage.Claims, old.Spec.Storage.Claims, claimsPath)...)
+	}
+	oldClaims := map[string]StorageClaim{}
+	for _, claim := range old.Spec.Storage.Claims {
+		oldClaims[claim.Name] = claim
+	}
+	for i, claim := range cur.Spec.Storage.Claims {
+		oldClaim, ok := oldClaims[claim.Name]
+		if !ok {
+			continue
+		}
+		claimPath := claimsPath.Index(i)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(
+			claim.StorageClassName, oldClaim.StorageClassName, claimPath.Child("storageClassName"))...)
+		allErrs = append(allErrs, apivalidation.ValidateImmutableField(
+			claim.AccessModes, oldClaim.AccessModes, claimPath.Child("accessModes"))...)
+		if claim.Size.Cmp(oldClaim.Size) < 0 {
+			allErrs = append(allErrs, field.Forbidden(claimPath.Child("size"), "can not be less than previous value"))
+		}
+	}
+	return allErrs
+}
+
+// warnings 不影响创建, 但是会被忽略的配置
+func (r *ZwhDeployment) warnings() admission.Warnings {
+	var warnings admission.Warnings
+	if r.Spec.Expose != nil && r.Spec.Expose.Tls != nil && r.Spec.Expose.Tls.Enable &&
+		strings.ToLower(r.Spec.Expose.Mode) != ModeIngress {
+		warnings = append(warnings, "spec.expose.tls is ignored when mode is not ingress")
+	}
+	if r.Spec.Expose != nil && strings.ToLower(r.Spec.Expose.Mode) != ModeLoadBalancer &&
+		(r.Spec.Expose.LoadBalancerIP != "" || len(r.Spec.Expose.LoadBalancerSourceRanges) > 0 || r.Spec.Expose.LoadBalancerClass != "") {
+		warnings = append(warnings, "spec.expose.loadBalancer* fields are ignored when mode is not loadbalancer")
+	}
+	if r.Spec.Expose != nil && strings.ToLower(r.Spec.Expose.Mode) != ModeNodePort {
+		for _, port := range r.Spec.Ports {
+			if port.NodePort != 0 {
+				warnings = append(warnings, "spec.ports[*].nodePort is ignored when mode is not nodeport")
+				break
+			}
+		}
+	}
+	if r.Spec.Autoscaling != nil && r.Spec.Autoscaling.Enable && r.Spec.Replicas > 1 {
+		warnings = append(warnings, "spec.replicas is ignored when autoscaling is enabled")
+	}
+	// 填写了来源之后, 集群外部的流量需要经过 ingress controller 或者通过 cidr 允许
+	if policy := r.Spec.NetworkPolicy; policy != nil && len(policy.Ingress) > 0 && r.Spec.Expose != nil {
+		controller, cidr := false, false
+		for _, peer := range policy.Ingress {
+			controller = controller || peer.IngressController
+			cidr = cidr || peer.CIDR != ""
+		}
+		switch strings.ToLower(r.Spec.Expose.Mode) {
+		case ModeIngress, ModeGateway:
+			if !controller {
+				warnings = append(warnings, "spec.networkPolicy.ingress does not allow the ingress controller, traffic from the ingress or gateway will be denied")
+			}
+		case ModeNodePort, ModeLoadBalancer:
+			if !cidr {
+				warnings = append(warnings, "spec.networkPolicy.ingress does not allow any cidr, traffic from outside the cluster will be denied")
+			}
+		}
+	}
+	if r.Spec.WorkloadKind == WorkloadKindStatefulSet && (r.Spec.AutoRollback || r.Spec.ProgressDeadlineSeconds != nil) {
+		warnings = append(warnings, "spec.autoRollback and spec.progressDeadlineSeconds are ignored when workloadKind is StatefulSet")
+	}
+	return warnings
+}
+
+func (r *ZwhDeployment) toInvalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "ZwhDeployment"}, r.Name, allErrs)
+}
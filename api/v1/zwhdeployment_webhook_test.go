@@ -0,0 +1,607 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newValidZwhDeployment() *ZwhDeployment {
+	return &ZwhDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "zwhdeployment-sample", Namespace: "default"},
+		Spec: ZwhDeploymentSpec{
+			Image:    "registry.example.com:5000/team/nginx:1.25",
+			Port:     80,
+			Replicas: 1,
+			Expose: &Expose{
+				Mode:          "Ingress",
+				IngressDomain: "www.example.com",
+			},
+		},
+	}
+}
+
+// causeFields 返回校验错误中所有出错字段的路径
+func causeFields(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok || !apierrors.IsInvalid(err) {
+		t.Fatalf("expected an Invalid StatusError, got %v", err)
+	}
+	var fields []string
+	for _, cause := range statusErr.ErrStatus.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	return fields
+}
+
+func TestZwhDeployment_Default(t *testing.T) {
+	md := newValidZwhDeployment()
+	md.Spec.Replicas = 0
+	md.Spec.Size = "Small"
+	md.Spec.Expose.Tls = &Tls{Enable: true}
+	md.Spec.Storage = &Storage{Claims: []StorageClaim{{Name: "data", Size: resource.MustParse("1Gi")}}}
+	md.Default()
+
+	want := newValidZwhDeployment().Spec
+	want.Replicas = 1
+	want.WorkloadKind = WorkloadKindDeployment
+	want.Size = SizeSmall
+	limit := int32(10)
+	want.RevisionHistoryLimit = &limit
+	want.Expose.Mode = ModeIngress
+	want.Expose.ServicePort = 80
+	want.Expose.IngressClassName = "nginx"
+	want.Expose.Tls = &Tls{Enable: true, Issuer: TlsIssuerSelfSigned, SecretName: "zwhdeployment-sample"}
+	want.Storage = &Storage{
+		ReclaimPolicy: StorageReclaimRetain,
+		Claims: []StorageClaim{{
+			Name:        "data",
+			Size:        resource.MustParse("1Gi"),
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		}},
+	}
+	if !reflect.DeepEqual(md.Spec, want) {
+		t.Errorf("Default() got = %+v, want %+v", md.Spec, want)
+	}
+}
+
+func TestZwhDeployment_ValidateCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		mutate     func(md *ZwhDeployment)
+		wantFields []string
+		wantWarn   bool
+	}{
+		{
+			name:   "valid ingress",
+			mutate: func(md *ZwhDeployment) {},
+		},
+		{
+			name: "valid nodeport",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: ModeNodePort, NodePort: 30080}
+			},
+		},
+		{
+			name: "image with digest",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Image = "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			},
+		},
+		{
+			name: "invalid image",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Image = "Nginx:latest tag"
+			},
+			wantFields: []string{"spec.image"},
+		},
+		{
+			name: "port out of range",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Port = 70000
+			},
+			wantFields: []string{"spec.port"},
+		},
+		{
+			name: "ports without port",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Port = 0
+				md.Spec.Ports = []ContainerPort{
+					{Name: "web", ContainerPort: 8080},
+					{Name: "dns", ContainerPort: 53, Protocol: corev1.ProtocolTCP},
+					{Name: "dns-udp", ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+				}
+				md.Spec.Expose.Rules = []IngressRule{{Host: "www.example.com", PortName: "dns"}}
+			},
+		},
+		{
+			name: "invalid ports",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Port = 9000
+				md.Spec.Ports = []ContainerPort{
+					{Name: "web", ContainerPort: 8080},
+					{Name: "web", ContainerPort: 8080},
+					{Name: "Admin_Port", ContainerPort: 9090, Exposed: new(bool)},
+				}
+				md.Spec.Expose.Rules = []IngressRule{{Host: "www.example.com", PortName: "Admin_Port"}}
+			},
+			wantFields: []string{"spec.ports[1].name", "spec.ports[1].containerPort", "spec.ports[2].name",
+				"spec.port", "spec.expose.rules[0].portName"},
+		},
+		{
+			name: "primary port not exposed",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Ports = []ContainerPort{{Name: "web", ContainerPort: md.Spec.Port, Exposed: new(bool)}}
+			},
+			wantFields: []string{"spec.ports[0].exposed"},
+		},
+		{
+			name: "nodePort on primary port",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Ports = []ContainerPort{{Name: "web", ContainerPort: md.Spec.Port, NodePort: 30080}}
+				md.Spec.Expose = &Expose{Mode: ModeNodePort}
+			},
+		},
+		{
+			name: "nodePort outside nodeport mode",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Ports = []ContainerPort{{Name: "web", ContainerPort: md.Spec.Port, NodePort: 30080}}
+			},
+			wantWarn: true,
+		},
+		{
+			name: "sidecar sharing a volume",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Volumes = []corev1.Volume{{Name: "logs"}}
+				md.Spec.Storage = &Storage{Claims: []StorageClaim{{Name: "data", Size: resource.MustParse("1Gi")}}}
+				md.Spec.InitContainers = []corev1.Container{{Name: "migrate", Image: "migrate:v1"}}
+				md.Spec.Sidecars = []corev1.Container{{
+					Name:         "fluent-bit",
+					Image:        "fluent/fluent-bit:2.1",
+					VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/logs"}, {Name: "data", MountPath: "/data"}},
+				}}
+			},
+		},
+		{
+			name: "invalid sidecars",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.InitContainers = []corev1.Container{{Name: md.Name, Image: "migrate:v1"}}
+				md.Spec.Sidecars = []corev1.Container{{
+					Name:         "proxy",
+					VolumeMounts: []corev1.VolumeMount{{Name: "missing", MountPath: "/tmp"}},
+				}}
+			},
+			wantFields: []string{"spec.initContainers[0].name", "spec.sidecars[0].image", "spec.sidecars[0].volumeMounts[0].name"},
+		},
+		{
+			name: "config and secretRefs",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Config = &Config{
+					Data:  map[string]string{"LOG_LEVEL": "info"},
+					Files: map[string]string{"app.yaml": "debug: false"},
+				}
+				md.Spec.SecretRefs = []SecretRef{{Name: "db-credentials"}, {Name: "tls-keys", MountPath: "/etc/tls"}}
+			},
+		},
+		{
+			name: "invalid config keys",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Config = &Config{
+					Data:      map[string]string{"1-level": "info", "app.yaml": "x"},
+					Files:     map[string]string{"app.yaml": "debug: false"},
+					MountPath: "etc/config",
+				}
+				md.Spec.SecretRefs = []SecretRef{{Name: "DB", MountPath: "tls"}}
+			},
+			wantFields: []string{"spec.config.data[1-level]", "spec.config.files[app.yaml]", "spec.config.mountPath",
+				"spec.secretRefs[0].name", "spec.secretRefs[0].mountPath"},
+		},
+		{
+			name: "unknown mode",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose.Mode = "loadbalance"
+			},
+			wantFields: []string{"spec.expose.mode"},
+		},
+		{
+			name: "valid clusterip",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: "ClusterIP"}
+			},
+		},
+		{
+			name: "loadbalancer with bad ip and range",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{
+					Mode:                     ModeLoadBalancer,
+					LoadBalancerIP:           "10.0.0.300",
+					LoadBalancerSourceRanges: []string{"10.0.0.0/8", "192.168.0.1"},
+				}
+			},
+			wantFields: []string{"spec.expose.loadBalancerIP", "spec.expose.loadBalancerSourceRanges[1]"},
+		},
+		{
+			name: "gateway without name and bad path",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: ModeGateway, Gateway: &Gateway{Paths: []GatewayPath{{Value: "api"}}}}
+			},
+			wantFields: []string{"spec.expose.gateway.name", "spec.expose.gateway.paths[0].value"},
+		},
+		{
+			name: "nodeport without nodePort",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: ModeNodePort}
+			},
+			wantFields: []string{"spec.expose.nodePort"},
+		},
+		{
+			name: "nodePort out of range",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: ModeNodePort, NodePort: 80}
+			},
+			wantFields: []string{"spec.expose.nodePort"},
+		},
+		{
+			name: "ingress without domain",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose.IngressDomain = ""
+			},
+			wantFields: []string{"spec.expose.ingressDomain"},
+		},
+		{
+			name: "ingress rules without domain",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose.IngressDomain = ""
+				md.Spec.Expose.Rules = []IngressRule{
+					{Host: "api.example.com", Path: "/v1", RewriteTarget: "/"},
+					{Host: "www.example.com", Path: "static", RewriteTarget: "/$2"},
+				}
+			},
+			wantFields: []string{"spec.expose.rules[1].path", "spec.expose.rules[1].rewriteTarget"},
+		},
+		{
+			name: "invalid domain",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose.IngressDomain = "www_example.com"
+			},
+			wantFields: []string{"spec.expose.ingressDomain"},
+		},
+		{
+			name: "wildcard domain",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose.IngressDomain = "*.example.com"
+			},
+		},
+		{
+			name: "issuer without issuerName",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose.Tls = &Tls{Enable: true, Issuer: TlsIssuerClusterIssuer}
+			},
+			wantFields: []string{"spec.expose.tls.issuerName"},
+		},
+		{
+			name: "tls on nodeport",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: ModeNodePort, NodePort: 30080, Tls: &Tls{Enable: true}}
+			},
+			wantWarn: true,
+		},
+		{
+			name: "autoscaling min greater than max",
+			mutate: func(md *ZwhDeployment) {
+				minReplicas, cpu := int32(5), int32(0)
+				md.Spec.Autoscaling = &Autoscaling{Enable: true, MinReplicas: &minReplicas, MaxReplicas: 3, TargetCPUUtilizationPercentage: &cpu}
+			},
+			wantFields: []string{"spec.autoscaling.minReplicas", "spec.autoscaling.targetCPUUtilizationPercentage"},
+		},
+		{
+			name: "autoscaling ignores replicas",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Replicas = 3
+				md.Spec.Autoscaling = &Autoscaling{Enable: true, MaxReplicas: 5}
+			},
+			wantWarn: true,
+		},
+		{
+			name: "canary strategy",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Strategy = &Strategy{Canary: &CanaryStrategy{Steps: []CanaryStep{
+					{Weight: 10, Pause: &metav1.Duration{Duration: 5 * time.Minute}},
+					{Weight: 50, Manual: true},
+				}}}
+			},
+		},
+		{
+			name: "canary on statefulset with invalid steps",
+			mutate: func(md *ZwhDeployment) {
+				replicas := int32(0)
+				md.Spec.WorkloadKind = WorkloadKindStatefulSet
+				md.Spec.Expose = &Expose{Mode: ModeClusterIP}
+				md.Spec.Strategy = &Strategy{Canary: &CanaryStrategy{Replicas: &replicas, Steps: []CanaryStep{
+					{Weight: 120, Pause: &metav1.Duration{Duration: -time.Minute}},
+				}}}
+			},
+			wantFields: []string{"spec.strategy.canary", "spec.strategy.canary", "spec.strategy.canary.steps[0].weight",
+				"spec.strategy.canary.steps[0].pause", "spec.strategy.canary.replicas"},
+		},
+		{
+			name: "blueGreen strategy",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Strategy = &Strategy{BlueGreen: &BlueGreenStrategy{PreviewHost: "preview.example.com"}}
+			},
+		},
+		{
+			name: "blueGreen with canary and invalid previewHost",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Strategy = &Strategy{
+					Canary: &CanaryStrategy{Steps: []CanaryStep{{Weight: 100}}},
+					BlueGreen: &BlueGreenStrategy{
+						PreviewHost:    "www.example.com",
+						ScaleDownDelay: &metav1.Duration{Duration: -time.Second},
+					},
+				}
+			},
+			wantFields: []string{"spec.strategy.blueGreen", "spec.strategy.blueGreen.previewHost", "spec.strategy.blueGreen.scaleDownDelay"},
+		},
+		{
+			name: "blueGreen previewHost outside ingress mode",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: ModeClusterIP}
+				md.Spec.Strategy = &Strategy{BlueGreen: &BlueGreenStrategy{PreviewHost: "preview.example.com"}}
+			},
+			wantFields: []string{"spec.strategy.blueGreen.previewHost"},
+		},
+		{
+			name: "autoRollback on statefulset",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.WorkloadKind = WorkloadKindStatefulSet
+				md.Spec.AutoRollback = true
+			},
+			wantWarn: true,
+		},
+		{
+			name: "rollback to an invalid revision",
+			mutate: func(md *ZwhDeployment) {
+				md.Annotations = map[string]string{AnnotationRollbackTo: "previous"}
+			},
+			wantFields: []string{"metadata.annotations[apps.zwh.com/rollback-to]"},
+		},
+		{
+			name: "scheduling",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.NodeSelector = map[string]string{"node-role.kubernetes.io/batch": ""}
+				md.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Value: "batch", Effect: corev1.TaintEffectNoSchedule}}
+				md.Spec.PriorityClassName = "high-priority"
+				md.Spec.SpreadAcrossZones = true
+			},
+		},
+		{
+			name: "invalid scheduling",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.NodeSelector = map[string]string{"disk type": "ssd"}
+				md.Spec.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists, Value: "batch"}, {Value: "batch"}}
+				md.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+					{MaxSkew: 0, TopologyKey: corev1.LabelTopologyZone, WhenUnsatisfiable: corev1.DoNotSchedule},
+					{MaxSkew: 1, TopologyKey: corev1.LabelTopologyZone, WhenUnsatisfiable: corev1.DoNotSchedule},
+				}
+				md.Spec.PriorityClassName = "High"
+			},
+			wantFields: []string{"spec.nodeSelector", "spec.tolerations[0].value", "spec.tolerations[1].operator",
+				"spec.topologySpreadConstraints[0].maxSkew", "spec.topologySpreadConstraints[1]", "spec.priorityClassName"},
+		},
+		{
+			name: "serviceAccount with rbac",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.ServiceAccount = &ServiceAccount{Create: true}
+				md.Spec.RBAC = &RBAC{Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "watch"}}}}
+			},
+		},
+		{
+			name: "invalid serviceAccount and rbac",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.ServiceAccount = &ServiceAccount{Create: true, Name: "app"}
+				md.Spec.RBAC = &RBAC{Rules: []rbacv1.PolicyRule{{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}}}}
+			},
+			wantFields: []string{"spec.serviceAccount.name", "spec.rbac.rules[0].nonResourceURLs",
+				"spec.rbac.rules[0].apiGroups", "spec.rbac.rules[0].resources"},
+		},
+		{
+			name: "rbac without serviceAccount",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.RBAC = &RBAC{Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}}}}
+			},
+			wantFields: []string{"spec.serviceAccount"},
+		},
+		{
+			name: "networkPolicy",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.NetworkPolicy = &NetworkPolicy{
+					Ingress: []NetworkPolicyPeer{{IngressController: true}, {ZwhDeployment: "frontend"}, {Namespace: "monitoring"}},
+					Egress: []NetworkPolicyEgress{
+						{NetworkPolicyPeer: NetworkPolicyPeer{ZwhDeployment: "mysql"}, Ports: []NetworkPolicyPort{{Port: 3306}}},
+						{NetworkPolicyPeer: NetworkPolicyPeer{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}},
+					},
+				}
+			},
+		},
+		{
+			name: "invalid networkPolicy",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.NetworkPolicy = &NetworkPolicy{
+					Ingress: []NetworkPolicyPeer{{IngressController: true}, {ZwhDeployment: "frontend", CIDR: "10.0.0.0/8"}, {}},
+					Egress: []NetworkPolicyEgress{
+						{NetworkPolicyPeer: NetworkPolicyPeer{CIDR: "10.0.0.0/8", Except: []string{"192.168.0.0/16"}}, Ports: []NetworkPolicyPort{{Port: 0}}},
+					},
+				}
+			},
+			wantFields: []string{"spec.networkPolicy.ingress[1]", "spec.networkPolicy.ingress[2]",
+				"spec.networkPolicy.egress[0].except[0]", "spec.networkPolicy.egress[0].ports[0].port"},
+		},
+		{
+			name: "networkPolicy without the ingress controller",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.NetworkPolicy = &NetworkPolicy{Ingress: []NetworkPolicyPeer{{ZwhDeployment: "frontend"}}}
+			},
+			wantWarn: true,
+		},
+		{
+			name: "networkPolicy without cidr in nodeport mode",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Expose = &Expose{Mode: ModeNodePort, NodePort: 30080}
+				md.Spec.NetworkPolicy = &NetworkPolicy{Ingress: []NetworkPolicyPeer{{ZwhDeployment: "frontend"}}}
+			},
+			wantWarn: true,
+		},
+		{
+			name: "networkPolicy with only egress",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.NetworkPolicy = &NetworkPolicy{Egress: []NetworkPolicyEgress{{NetworkPolicyPeer: NetworkPolicyPeer{ZwhDeployment: "mysql"}}}}
+			},
+		},
+		{
+			name: "registry credentials",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "harbor"}}
+				md.Spec.RegistryCredentials = &RegistryCredentials{Name: "harbor-pull"}
+			},
+		},
+		{
+			name: "invalid registry credentials",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{}}
+				md.Spec.RegistryCredentials = &RegistryCredentials{Name: "Harbor_Pull"}
+			},
+			wantFields: []string{"spec.imagePullSecrets[0].name", "spec.registryCredentials.name"},
+		},
+		{
+			name: "disruptionBudget with both fields",
+			mutate: func(md *ZwhDeployment) {
+				minAvailable, maxUnavailable := intstr.FromString("150%"), intstr.FromInt(1)
+				md.Spec.DisruptionBudget = &DisruptionBudget{MinAvailable: &minAvailable, MaxUnavailable: &maxUnavailable}
+			},
+			wantFields: []string{"spec.disruptionBudget.maxUnavailable", "spec.disruptionBudget.minAvailable"},
+		},
+		{
+			name: "duplicate and empty claims",
+			mutate: func(md *ZwhDeployment) {
+				md.Spec.Storage = &Storage{Claims: []StorageClaim{
+					{Name: "data", Size: resource.MustParse("1Gi")},
+					{Name: "data"},
+				}}
+			},
+			wantFields: []string{"spec.storage.claims[1].name", "spec.storage.claims[1].size"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := newValidZwhDeployment()
+			tt.mutate(md)
+			warnings, err := md.ValidateCreate()
+			if got := causeFields(t, err); !equalFields(got, tt.wantFields) {
+				t.Errorf("ValidateCreate() fields = %v, want %v, err: %v", got, tt.wantFields, err)
+			}
+			if (len(warnings) > 0) != tt.wantWarn {
+				t.Errorf("ValidateCreate() warnings = %v, wantWarn %v", warnings, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestZwhDeployment_ValidateUpdate(t *testing.T) {
+	withClaim := func(md *ZwhDeployment, size, class string) {
+		md.Spec.Storage = &Storage{Claims: []StorageClaim{{
+			Name:             "data",
+			Size:             resource.MustParse(size),
+			StorageClassName: class,
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		}}}
+	}
+	tests := []struct {
+		name       string
+		old        func(md *ZwhDeployment)
+		new        func(md *ZwhDeployment)
+		wantFields []string
+	}{
+		{
+			name: "scale replicas",
+			old:  func(md *ZwhDeployment) {},
+			new: func(md *ZwhDeployment) {
+				md.Spec.Replicas = 3
+			},
+		},
+		{
+			name: "old object without defaults",
+			old: func(md *ZwhDeployment) {
+				md.Spec.Storage = &Storage{Claims: []StorageClaim{{Name: "data", Size: resource.MustParse("1Gi")}}}
+			},
+			new: func(md *ZwhDeployment) {
+				withClaim(md, "1Gi", "")
+				md.Default()
+			},
+		},
+		{
+			name: "change workloadKind",
+			old:  func(md *ZwhDeployment) {},
+			new: func(md *ZwhDeployment) {
+				md.Spec.WorkloadKind = WorkloadKindStatefulSet
+			},
+			wantFields: []string{"spec.workloadKind"},
+		},
+		{
+			name: "expand claim",
+			old:  func(md *ZwhDeployment) { withClaim(md, "1Gi", "standard") },
+			new:  func(md *ZwhDeployment) { withClaim(md, "2Gi", "standard") },
+		},
+		{
+			name:       "shrink claim and change class",
+			old:        func(md *ZwhDeployment) { withClaim(md, "2Gi", "standard") },
+			new:        func(md *ZwhDeployment) { withClaim(md, "1Gi", "fast") },
+			wantFields: []string{"spec.storage.claims[0].storageClassName", "spec.storage.claims[0].size"},
+		},
+		{
+			name: "statefulset claims",
+			old: func(md *ZwhDeployment) {
+				md.Spec.WorkloadKind = WorkloadKindStatefulSet
+				withClaim(md, "1Gi", "standard")
+			},
+			new: func(md *ZwhDeployment) {
+				md.Spec.WorkloadKind = WorkloadKindStatefulSet
+				withClaim(md, "2Gi", "standard")
+			},
+			wantFields: []string{"spec.storage.claims"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldMd, newMd := newValidZwhDeployment(), newValidZwhDeployment()
+			tt.old(oldMd)
+			tt.new(newMd)
+			_, err := newMd.ValidateUpdate(oldMd)
+			if got := causeFields(t, err); !equalFields(got, tt.wantFields) {
+				t.Errorf("ValidateUpdate() fields = %v, want %v, err: %v", got, tt.wantFields, err)
+			}
+		})
+	}
+}
+
+func equalFields(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,13 +2,27 @@ package controller
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	networkv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	myAppsv1 "zwh.com/pkg/zwh-deployment/api/v1"
 )
@@ -34,10 +48,1706 @@ func NewDeployment(md *myAppsv1.ZwhDeployment) (*appsv1.Deployment, error) {
 	if err := yaml.Unmarshal(content, deploy); err != nil {
 		return nil, err
 	}
+	if err := setPodTemplate(md, &deploy.Spec.Template); err != nil {
+		return nil, err
+	}
+	if autoscalingEnabled(md) {
+		deploy.Spec.Replicas = minReplicas(md)
+	}
+	deploy.Spec.ProgressDeadlineSeconds = md.Spec.ProgressDeadlineSeconds
 	return deploy, nil
 }
 
+// rollbackTemplate 当前 generation 的发布失败并且已经回滚时, 获取上一次成功发布的 pod 模板, 否则返回 nil
+func rollbackTemplate(md *myAppsv1.ZwhDeployment) *corev1.PodTemplateSpec {
+	rollout := md.Status.Rollout
+	if rollout == nil || rollout.Phase != myAppsv1.RolloutPhaseRolledBack || rollout.FailedGeneration != md.Generation {
+		return nil
+	}
+	return rollout.LastGoodTemplate
+}
+
+// rolloutFailed 判断当前 generation 的发布是否已经失败, 修改 spec 之前不会重新发布
+func rolloutFailed(md *myAppsv1.ZwhDeployment) bool {
+	rollout := md.Status.Rollout
+	return rollout != nil && rollout.FailedGeneration == md.Generation &&
+		(rollout.Phase == myAppsv1.RolloutPhaseFailed || rollout.Phase == myAppsv1.RolloutPhaseRolledBack)
+}
+
+// updateRollout 根据 deployment 的滚动更新结果更新 status.rollout, 滚动更新完成时记录当前的 pod 模板
+// 返回 true 表示当前 generation 的发布刚刚超过 progressDeadlineSeconds 失败
+func updateRollout(md *myAppsv1.ZwhDeployment, deploy *appsv1.Deployment) bool {
+	if rolloutFailed(md) {
+		return false
+	}
+	if md.Status.Rollout == nil {
+		md.Status.Rollout = new(myAppsv1.RolloutStatus)
+	}
+	rollout := md.Status.Rollout
+	image := deploy.Spec.Template.Spec.Containers[0].Image
+	// deployment controller 还没有处理最新的模板时, Progressing condition 还是上一次的结果
+	failure := ""
+	if deploy.Status.ObservedGeneration >= deploy.Generation {
+		failure = deploymentFailure(deploy)
+	}
+	switch {
+	case deploymentRolledOut(deploy):
+		rollout.Phase = myAppsv1.RolloutPhaseComplete
+		rollout.Message = ""
+		rollout.LastGoodImage = image
+		rollout.LastGoodGeneration = md.Generation
+		rollout.LastGoodTemplate = deploy.Spec.Template.DeepCopy()
+	case failure != "":
+		rollout.Phase = myAppsv1.RolloutPhaseFailed
+		rollout.FailedImage = image
+		rollout.FailedGeneration = md.Generation
+		rollout.Message = failure
+		return true
+	default:
+		rollout.Phase = myAppsv1.RolloutPhaseProgressing
+	}
+	return false
+}
+
+// canaryName canary 的 deployment、service 和 ingress 名称为 <md名称>-canary
+func canaryName(md *myAppsv1.ZwhDeployment) string {
+	return md.Name + "-canary"
+}
+
+// canaryEnabled 判断是否使用金丝雀发布, 只支持 Deployment 和 ingress 模式
+func canaryEnabled(md *myAppsv1.ZwhDeployment) bool {
+	return md.Spec.Strategy != nil && md.Spec.Strategy.Canary != nil &&
+		workloadKind(md) == myAppsv1.WorkloadKindDeployment &&
+		strings.ToLower(md.Spec.Expose.Mode) == myAppsv1.ModeIngress
+}
+
+// stableZwhDeployment 获取稳定版本使用的配置
+// 金丝雀发布或者蓝绿发布还没有推广时, 稳定版本保持原来的镜像, 其他配置和 md 相同
+func stableZwhDeployment(md *myAppsv1.ZwhDeployment) *myAppsv1.ZwhDeployment {
+	image := md.Spec.Image
+	if canary := md.Status.Canary; canary != nil && canary.StableImage != "" &&
+		canary.Phase != myAppsv1.CanaryPhasePromoting && canary.Phase != myAppsv1.CanaryPhasePromoted {
+		image = canary.StableImage
+	}
+	if blueGreen := md.Status.BlueGreen; blueGreen != nil && blueGreen.ActiveImage != "" {
+		switch blueGreen.Phase {
+		case myAppsv1.BlueGreenPhasePromoted:
+		case myAppsv1.BlueGreenPhasePromoting:
+			// 推广时稳定版本滚动更新到已经验证过的 preview 镜像
+			image = blueGreen.PreviewImage
+		default:
+			image = blueGreen.ActiveImage
+		}
+	}
+	if image == md.Spec.Image {
+		return md
+	}
+	stable := md.DeepCopy()
+	stable.Spec.Image = image
+	return stable
+}
+
+// NewCanaryDeployment 生成 canary 版本的 deployment
+// pod 使用 <md名称>-canary 作为 app 标签, 不会被稳定版本的 deployment 和 service 选中
+func NewCanaryDeployment(md *myAppsv1.ZwhDeployment) (*appsv1.Deployment, error) {
+	deploy, err := NewDeployment(md)
+	if err != nil {
+		return nil, err
+	}
+	canary := md.Spec.Strategy.Canary
+	deploy.Name = canaryName(md)
+	deploy.Labels = map[string]string{"app": canaryName(md)}
+	deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": canaryName(md)}}
+	deploy.Spec.Template.Labels = map[string]string{"app": canaryName(md)}
+	setScheduling(md, &deploy.Spec.Template)
+	replicas := int32(1)
+	if canary.Replicas != nil {
+		replicas = *canary.Replicas
+	}
+	deploy.Spec.Replicas = &replicas
+	if canary.ProgressDeadlineSeconds != nil {
+		deploy.Spec.ProgressDeadlineSeconds = canary.ProgressDeadlineSeconds
+	}
+	return deploy, nil
+}
+
+// NewCanaryService 生成只选中 canary pod 的 service, 作为 canary ingress 的后端
+func NewCanaryService(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
+	svc, err := NewService(md)
+	if err != nil {
+		return nil, err
+	}
+	svc.Name = canaryName(md)
+	svc.Spec.Selector = map[string]string{"app": canaryName(md)}
+	return svc, nil
+}
+
+// canaryAnnotation ingress-nginx 把 ingress 标记为 canary 的注解, canaryWeightAnnotation 为转发到 canary 的流量百分比
+const (
+	canaryAnnotation       = "nginx.ingress.kubernetes.io/canary"
+	canaryWeightAnnotation = "nginx.ingress.kubernetes.io/canary-weight"
+)
+
+// NewCanaryIngress 生成和稳定版本相同规则的 canary ingress, 按照 weight 把流量转发到 canary service
+// canary ingress 的其他注解从稳定版本对应的 ingress 继承, 所以包含所有的规则并且不需要重写注解
+func NewCanaryIngress(md *myAppsv1.ZwhDeployment, weight int32) (*networkv1.Ingress, error) {
+	ig, err := buildIngress(md, canaryName(md), ingressRules(md))
+	if err != nil {
+		return nil, err
+	}
+	delete(ig.Annotations, rewriteTargetAnnotation)
+	for i := range ig.Spec.Rules {
+		for j := range ig.Spec.Rules[i].HTTP.Paths {
+			ig.Spec.Rules[i].HTTP.Paths[j].Backend.Service.Name = canaryName(md)
+		}
+	}
+	if ig.Annotations == nil {
+		ig.Annotations = make(map[string]string, 2)
+	}
+	ig.Annotations[canaryAnnotation] = "true"
+	ig.Annotations[canaryWeightAnnotation] = strconv.Itoa(int(weight))
+	return ig, nil
+}
+
+// canaryAction 金丝雀发布在一次 reconcile 中要执行的动作
+type canaryAction int
+
+const (
+	canaryWait    canaryAction = iota // 等待 canary 就绪或者暂停结束
+	canaryPause                       // 人工暂停, 等待 promote 注解
+	canaryAdvance                     // 进入下一步
+	canaryPromote                     // 所有步骤都已完成, 推广到稳定版本
+	canaryAbort                       // 中止发布, 流量切回稳定版本
+)
+
+// nextCanaryAction 根据 canary deployment 的状态和当前步骤的暂停配置决定下一步的动作
+// canary 超过 progressDeadlineSeconds 没有就绪时中止, 返回的 message 说明原因
+func nextCanaryAction(md *myAppsv1.ZwhDeployment, canary *appsv1.Deployment, promote, abort bool, now time.Time) (canaryAction, string) {
+	if abort {
+		return canaryAbort, fmt.Sprintf("aborted by the %s annotation", myAppsv1.AnnotationCanaryAbort)
+	}
+	if failure := deploymentFailure(canary); failure != "" {
+		return canaryAbort, fmt.Sprintf("canary deployment %s failed: %s", canary.Name, failure)
+	}
+	// canary 的 pod 没有全部就绪时不切换流量
+	if !deploymentAvailable(canary) {
+		return canaryWait, ""
+	}
+	steps := md.Spec.Strategy.Canary.Steps
+	status := md.Status.Canary
+	if int(status.CurrentStep) >= len(steps) {
+		return canaryPromote, ""
+	}
+	step := steps[status.CurrentStep]
+	if !promote {
+		if step.Manual {
+			return canaryPause, ""
+		}
+		if step.Pause != nil && status.StepStartTime != nil &&
+			now.Sub(status.StepStartTime.Time) < step.Pause.Duration {
+			return canaryWait, ""
+		}
+	}
+	if int(status.CurrentStep) >= len(steps)-1 {
+		return canaryPromote, ""
+	}
+	return canaryAdvance, ""
+}
+
+// canaryCondition 根据金丝雀发布的进度生成 Canary condition 的信息
+func canaryCondition(md *myAppsv1.ZwhDeployment) (message, status, reason string) {
+	canary := md.Status.Canary
+	steps := len(md.Spec.Strategy.Canary.Steps)
+	step := int(canary.CurrentStep) + 1
+	switch canary.Phase {
+	case myAppsv1.CanaryPhasePaused:
+		return fmt.Sprintf(myAppsv1.ConditionMessageCanaryPausedFmt, md.Name, step, steps, myAppsv1.AnnotationCanaryPromote),
+			myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonCanaryPaused
+	case myAppsv1.CanaryPhasePromoting:
+		return fmt.Sprintf(myAppsv1.ConditionMessageCanaryRolloutFmt, md.Name),
+			myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonCanaryProgressing
+	case myAppsv1.CanaryPhasePromoted:
+		return fmt.Sprintf(myAppsv1.ConditionMessageCanaryOKFmt, md.Name),
+			myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonCanaryPromoted
+	case myAppsv1.CanaryPhaseAborted:
+		return canary.Message, myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonCanaryAborted
+	}
+	return fmt.Sprintf(myAppsv1.ConditionMessageCanaryStepFmt, md.Name, step, steps, canaryWeight(md)),
+		myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonCanaryProgressing
+}
+
+// canaryWeight 获取当前步骤转发到 canary 的流量百分比
+func canaryWeight(md *myAppsv1.ZwhDeployment) int32 {
+	steps := md.Spec.Strategy.Canary.Steps
+	current := int(md.Status.Canary.CurrentStep)
+	if current >= len(steps) {
+		current = len(steps) - 1
+	}
+	return steps[current].Weight
+}
+
+// deploymentFailure 获取 deployment 超过 progressDeadlineSeconds 没有就绪的原因, 没有失败时为空
+func deploymentFailure(deploy *appsv1.Deployment) string {
+	for _, condition := range deploy.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse {
+			return condition.Message
+		}
+	}
+	return ""
+}
+
+// deploymentAvailable 判断 deployment 最新版本的 pod 是否全部可用
+func deploymentAvailable(deploy *appsv1.Deployment) bool {
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Spec.Replicas != nil &&
+		deploy.Status.UpdatedReplicas >= *deploy.Spec.Replicas &&
+		deploy.Status.AvailableReplicas >= *deploy.Spec.Replicas
+}
+
+// previewName preview 的 deployment、service 和 ingress 名称为 <md名称>-preview
+func previewName(md *myAppsv1.ZwhDeployment) string {
+	return md.Name + "-preview"
+}
+
+// colorLabel preview pod 的颜色标签, 用来区分蓝绿发布的两个版本
+const colorLabel = "apps.zwh.com/color"
+
+// blueGreenEnabled 判断是否使用蓝绿发布, 只支持 Deployment
+func blueGreenEnabled(md *myAppsv1.ZwhDeployment) bool {
+	return md.Spec.Strategy != nil && md.Spec.Strategy.BlueGreen != nil &&
+		workloadKind(md) == myAppsv1.WorkloadKindDeployment
+}
+
+// blueGreenSwitched 判断主 service 的流量是否已经切换到 preview
+func blueGreenSwitched(md *myAppsv1.ZwhDeployment) bool {
+	blueGreen := md.Status.BlueGreen
+	return blueGreen != nil &&
+		(blueGreen.Phase == myAppsv1.BlueGreenPhaseSwitched || blueGreen.Phase == myAppsv1.BlueGreenPhasePromoting)
+}
+
+// otherColor 获取另一个颜色, 新版本使用和当前版本不同的颜色
+func otherColor(color string) string {
+	if color == myAppsv1.BlueGreenColorGreen {
+		return myAppsv1.BlueGreenColorBlue
+	}
+	return myAppsv1.BlueGreenColorGreen
+}
+
+// setServiceSelector 蓝绿发布切换流量后, 主 service 选中 preview 的 pod
+func setServiceSelector(md *myAppsv1.ZwhDeployment, svc *corev1.Service) {
+	if blueGreenSwitched(md) {
+		svc.Spec.Selector = map[string]string{"app": previewName(md)}
+	}
+}
+
+// NewPreviewDeployment 生成蓝绿发布中新版本的 deployment, 副本数和稳定版本相同
+// pod 使用 <md名称>-preview 作为 app 标签, 并带有颜色标签
+func NewPreviewDeployment(md *myAppsv1.ZwhDeployment) (*appsv1.Deployment, error) {
+	preview := md.DeepCopy()
+	preview.Spec.Image = md.Status.BlueGreen.PreviewImage
+	deploy, err := NewDeployment(preview)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{"app": previewName(md)}
+	deploy.Name = previewName(md)
+	deploy.Labels = labels
+	deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	deploy.Spec.Template.Labels = map[string]string{"app": previewName(md), colorLabel: md.Status.BlueGreen.PreviewColor}
+	setScheduling(md, &deploy.Spec.Template)
+	return deploy, nil
+}
+
+// NewPreviewService 生成只选中 preview pod 的 service, 推广之前用来测试新版本
+func NewPreviewService(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
+	content, err := parseTemplate(md, "service.yaml")
+	if err != nil {
+		return nil, err
+	}
+	svc := new(corev1.Service)
+	if err := yaml.Unmarshal(content, svc); err != nil {
+		return nil, err
+	}
+	svc.Name = previewName(md)
+	svc.Spec.Selector = map[string]string{"app": previewName(md)}
+	svc.Spec.Ports = newServicePorts(md, false)
+	return svc, nil
+}
+
+// NewPreviewIngress 把 previewHost 的流量转发到 preview service, 路径和稳定版本的 ingress 相同
+// 单独的重写路径 ingress 中的规则不在 preview 中, 证书只包含稳定版本的域名, preview 不开启 tls
+func NewPreviewIngress(md *myAppsv1.ZwhDeployment) (*networkv1.Ingress, error) {
+	ig, err := NewIngress(md)
+	if err != nil {
+		return nil, err
+	}
+	ig.Name = previewName(md)
+	ig.Spec.TLS = nil
+	rule := networkv1.IngressRule{Host: md.Spec.Strategy.BlueGreen.PreviewHost}
+	rule.HTTP = new(networkv1.HTTPIngressRuleValue)
+	seen := map[string]bool{}
+	for _, r := range ig.Spec.Rules {
+		for _, path := range r.HTTP.Paths {
+			// 不同域名的相同路径在 preview 域名下只保留一个
+			if seen[path.Path] {
+				continue
+			}
+			seen[path.Path] = true
+			path.Backend.Service.Name = previewName(md)
+			rule.HTTP.Paths = append(rule.HTTP.Paths, path)
+		}
+	}
+	ig.Spec.Rules = []networkv1.IngressRule{rule}
+	return ig, nil
+}
+
+// blueGreenAction 蓝绿发布在一次 reconcile 中要执行的动作
+type blueGreenAction int
+
+const (
+	blueGreenWait    blueGreenAction = iota // 等待 preview 就绪或者 scaleDownDelay 结束
+	blueGreenPause                          // preview 已经就绪, 等待 promote 注解
+	blueGreenSwitch                         // 把主 service 的流量切换到 preview
+	blueGreenPromote                        // 稳定版本滚动更新到新镜像
+	blueGreenAbort                          // 中止发布, 流量切回原来的版本
+)
+
+// nextBlueGreenAction 根据 preview deployment 的状态和当前阶段决定下一步的动作
+func nextBlueGreenAction(md *myAppsv1.ZwhDeployment, preview *appsv1.Deployment, promote, abort bool, now time.Time) (blueGreenAction, string) {
+	if abort {
+		return blueGreenAbort, fmt.Sprintf("aborted by the %s annotation", myAppsv1.AnnotationBlueGreenAbort)
+	}
+	strategy := md.Spec.Strategy.BlueGreen
+	status := md.Status.BlueGreen
+	if status.Phase == myAppsv1.BlueGreenPhaseSwitched {
+		// 旧版本保留 scaleDownDelay, 期间可以中止发布
+		if !promote && strategy.ScaleDownDelay != nil && status.SwitchTime != nil &&
+			now.Sub(status.SwitchTime.Time) < strategy.ScaleDownDelay.Duration {
+			return blueGreenWait, ""
+		}
+		return blueGreenPromote, ""
+	}
+	if failure := deploymentFailure(preview); failure != "" {
+		return blueGreenAbort, fmt.Sprintf("preview deployment %s failed: %s", preview.Name, failure)
+	}
+	if !deploymentAvailable(preview) {
+		return blueGreenWait, ""
+	}
+	if promote || strategy.AutoPromote {
+		return blueGreenSwitch, ""
+	}
+	return blueGreenPause, ""
+}
+
+// blueGreenCondition 根据蓝绿发布的进度生成 BlueGreen condition 的信息
+func blueGreenCondition(md *myAppsv1.ZwhDeployment) (message, status, reason string) {
+	blueGreen := md.Status.BlueGreen
+	switch blueGreen.Phase {
+	case myAppsv1.BlueGreenPhasePaused:
+		return fmt.Sprintf(myAppsv1.ConditionMessageBlueGreenPauseFmt, blueGreen.PreviewColor, md.Name, myAppsv1.AnnotationBlueGreenPromote),
+			myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonBlueGreenPaused
+	case myAppsv1.BlueGreenPhaseSwitched:
+		delay := time.Duration(0)
+		if md.Spec.Strategy.BlueGreen.ScaleDownDelay != nil {
+			delay = md.Spec.Strategy.BlueGreen.ScaleDownDelay.Duration
+		}
+		return fmt.Sprintf(myAppsv1.ConditionMessageBlueGreenSwapFmt, md.Name, blueGreen.PreviewColor, blueGreen.ActiveColor, delay),
+			myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonBlueGreenSwitched
+	case myAppsv1.BlueGreenPhasePromoting:
+		return fmt.Sprintf(myAppsv1.ConditionMessageBlueGreenRollFmt, md.Name, blueGreen.PreviewColor),
+			myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonBlueGreenPromoting
+	case myAppsv1.BlueGreenPhasePromoted:
+		return fmt.Sprintf(myAppsv1.ConditionMessageBlueGreenOKFmt, md.Name, blueGreen.ActiveColor),
+			myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonBlueGreenPromoted
+	case myAppsv1.BlueGreenPhaseAborted:
+		return blueGreen.Message, myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonBlueGreenAborted
+	}
+	return fmt.Sprintf(myAppsv1.ConditionMessageBlueGreenNotFmt, blueGreen.PreviewColor, md.Name),
+		myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonBlueGreenPreviewing
+}
+
+// deploymentRolledOut 判断 deployment 是否已经滚动更新完成, 所有的 pod 都是新版本并且可用
+func deploymentRolledOut(deploy *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.UpdatedReplicas == replicas &&
+		deploy.Status.Replicas == replicas &&
+		deploy.Status.AvailableReplicas == replicas
+}
+
+// NewStatefulSet workloadKind 为 StatefulSet 时使用, storage 中的 pvc 作为 volumeClaimTemplates
+func NewStatefulSet(md *myAppsv1.ZwhDeployment) (*appsv1.StatefulSet, error) {
+	content, err := parseTemplate(md, "statefulset.yaml")
+	if err != nil {
+		return nil, err
+	}
+	sts := new(appsv1.StatefulSet)
+	if err := yaml.Unmarshal(content, sts); err != nil {
+		return nil, err
+	}
+	if err := setPodTemplate(md, &sts.Spec.Template); err != nil {
+		return nil, err
+	}
+	if autoscalingEnabled(md) {
+		sts.Spec.Replicas = minReplicas(md)
+	}
+	if md.Spec.Storage == nil {
+		return sts, nil
+	}
+	for _, claim := range md.Spec.Storage.Claims {
+		sts.Spec.VolumeClaimTemplates = append(sts.Spec.VolumeClaimTemplates, corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   claim.Name,
+				Labels: map[string]string{"app": md.Name},
+			},
+			Spec: newPVCSpec(claim),
+		})
+	}
+	if pvcReclaimDelete(md) {
+		sts.Spec.PersistentVolumeClaimRetentionPolicy = &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+			WhenDeleted: appsv1.DeletePersistentVolumeClaimRetentionPolicyType,
+			WhenScaled:  appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+		}
+	}
+	return sts, nil
+}
+
+// setPodTemplate 把模板中不方便渲染的字段设置到 pod 模板中
+func setPodTemplate(md *myAppsv1.ZwhDeployment, template *corev1.PodTemplateSpec) error {
+	resources, err := newResources(md)
+	if err != nil {
+		return err
+	}
+	container := &template.Spec.Containers[0]
+	container.Image = pinnedImage(md, container.Image)
+	container.Ports = newContainerPorts(md)
+	container.Resources = resources
+	container.LivenessProbe, container.ReadinessProbe, container.StartupProbe = newProbes(md)
+	template.Spec.Volumes, container.VolumeMounts = newVolumes(md)
+	container.Env, container.EnvFrom = newEnv(md)
+	volumes, mounts := newConfigVolumes(md)
+	template.Spec.Volumes = append(template.Spec.Volumes, volumes...)
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	// 配置变化时 pod 模板的注解变化, deployment/statefulset 会滚动更新
+	if md.Status.ConfigHash != "" {
+		template.Annotations = map[string]string{configHashAnnotation: md.Status.ConfigHash}
+	}
+	// 边车和初始化容器与主容器在同一个 pod 中, 可以直接挂载 pod 的存储卷
+	for i := range md.Spec.InitContainers {
+		template.Spec.InitContainers = append(template.Spec.InitContainers, *md.Spec.InitContainers[i].DeepCopy())
+	}
+	for i := range md.Spec.Sidecars {
+		template.Spec.Containers = append(template.Spec.Containers, *md.Spec.Sidecars[i].DeepCopy())
+	}
+	setScheduling(md, template)
+	setSecurityContext(md, template)
+	template.Spec.ServiceAccountName = serviceAccountName(md)
+	template.Spec.AutomountServiceAccountToken = automountToken(md)
+	template.Spec.ImagePullSecrets = imagePullSecrets(md)
+	return nil
+}
+
+// setScheduling 设置 pod 的调度配置
+// spreadAcrossZones 生成的分布规则按照模板的 app 标签选择 pod, 修改模板标签后需要重新设置
+func setScheduling(md *myAppsv1.ZwhDeployment, template *corev1.PodTemplateSpec) {
+	spec := &template.Spec
+	spec.NodeSelector = nil
+	if len(md.Spec.NodeSelector) > 0 {
+		spec.NodeSelector = make(map[string]string, len(md.Spec.NodeSelector))
+		for k, v := range md.Spec.NodeSelector {
+			spec.NodeSelector[k] = v
+		}
+	}
+	spec.Tolerations = nil
+	for i := range md.Spec.Tolerations {
+		spec.Tolerations = append(spec.Tolerations, *md.Spec.Tolerations[i].DeepCopy())
+	}
+	spec.Affinity = md.Spec.Affinity.DeepCopy()
+	spec.PriorityClassName = md.Spec.PriorityClassName
+	spec.TopologySpreadConstraints = nil
+	for i := range md.Spec.TopologySpreadConstraints {
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, *md.Spec.TopologySpreadConstraints[i].DeepCopy())
+	}
+	if md.Spec.SpreadAcrossZones {
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints,
+			zoneSpreadConstraints(md.Spec.TopologySpreadConstraints, template.Labels["app"])...)
+	}
+}
+
+// zoneSpreadConstraints spreadAcrossZones 默认的分布规则
+// 可用区之间和节点之间的 pod 数量最多相差1个, 使用 ScheduleAnyway 避免可用区不足时 pod 无法调度
+func zoneSpreadConstraints(existing []corev1.TopologySpreadConstraint, app string) []corev1.TopologySpreadConstraint {
+	var constraints []corev1.TopologySpreadConstraint
+	for _, key := range []string{corev1.LabelTopologyZone, corev1.LabelHostname} {
+		configured := false
+		for _, c := range existing {
+			if c.TopologyKey == key {
+				configured = true
+				break
+			}
+		}
+		if configured {
+			continue
+		}
+		constraints = append(constraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       key,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+		})
+	}
+	return constraints
+}
+
+// setSecurityContext 设置 pod 和主容器的安全配置
+// securityProfile 为 restricted 时, 给所有容器补全没有填写的安全配置, 用户填写的值不会被覆盖
+func setSecurityContext(md *myAppsv1.ZwhDeployment, template *corev1.PodTemplateSpec) {
+	spec := &template.Spec
+	spec.SecurityContext = md.Spec.PodSecurityContext.DeepCopy()
+	spec.Containers[0].SecurityContext = md.Spec.SecurityContext.DeepCopy()
+	if md.Spec.SecurityProfile != myAppsv1.SecurityProfileRestricted {
+		return
+	}
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	if spec.SecurityContext.RunAsNonRoot == nil {
+		runAsNonRoot := true
+		spec.SecurityContext.RunAsNonRoot = &runAsNonRoot
+	}
+	if spec.SecurityContext.SeccompProfile == nil {
+		spec.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+	for _, container := range podContainers(spec) {
+		if container.SecurityContext == nil {
+			container.SecurityContext = &corev1.SecurityContext{}
+		}
+		sc := container.SecurityContext
+		if sc.AllowPrivilegeEscalation == nil {
+			allowPrivilegeEscalation := false
+			sc.AllowPrivilegeEscalation = &allowPrivilegeEscalation
+		}
+		if sc.Capabilities == nil {
+			sc.Capabilities = &corev1.Capabilities{}
+		}
+		if len(sc.Capabilities.Drop) == 0 {
+			sc.Capabilities.Drop = []corev1.Capability{"ALL"}
+		}
+		if sc.ReadOnlyRootFilesystem == nil {
+			readOnlyRootFilesystem := true
+			sc.ReadOnlyRootFilesystem = &readOnlyRootFilesystem
+		}
+	}
+}
+
+// newPodTemplate 生成 deployment/statefulset 中的 pod 模板
+func newPodTemplate(md *myAppsv1.ZwhDeployment) (*corev1.PodTemplateSpec, error) {
+	if workloadKind(md) == myAppsv1.WorkloadKindStatefulSet {
+		sts, err := NewStatefulSet(md)
+		if err != nil {
+			return nil, err
+		}
+		return &sts.Spec.Template, nil
+	}
+	deploy, err := NewDeployment(md)
+	if err != nil {
+		return nil, err
+	}
+	return &deploy.Spec.Template, nil
+}
+
+// podContainers pod 中所有的初始化容器和容器
+func podContainers(spec *corev1.PodSpec) []*corev1.Container {
+	containers := make([]*corev1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+	return containers
+}
+
+// baselineCapabilities baseline 等级允许添加的 capabilities
+var baselineCapabilities = map[corev1.Capability]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true, "FSETID": true, "KILL": true, "MKNOD": true,
+	"NET_BIND_SERVICE": true, "SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// baselineSysctls baseline 等级允许设置的 sysctls
+var baselineSysctls = map[string]bool{
+	"kernel.shm_rmid_forced": true, "net.ipv4.ip_local_port_range": true, "net.ipv4.ip_unprivileged_port_start": true,
+	"net.ipv4.tcp_syncookies": true, "net.ipv4.ping_group_range": true,
+}
+
+// securityViolations 按照 Pod Security Standards 检查 pod 是否满足选择的等级, 返回所有不满足的地方
+// 规则和 pod security admission 保持一致, 避免 deployment 创建成功但 pod 被 namespace 拒绝
+func securityViolations(profile string, spec *corev1.PodSpec) []string {
+	if profile == "" || profile == myAppsv1.SecurityProfilePrivileged {
+		return nil
+	}
+	var violations []string
+	// 1. baseline 等级
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		violations = append(violations, "pod must not use host namespaces")
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			violations = append(violations, fmt.Sprintf("volume %q must not use hostPath", volume.Name))
+		}
+	}
+	if spec.SecurityContext != nil {
+		for _, sysctl := range spec.SecurityContext.Sysctls {
+			if !baselineSysctls[sysctl.Name] {
+				violations = append(violations, fmt.Sprintf("pod must not set sysctl %s", sysctl.Name))
+			}
+		}
+		if seccompUnconfined(spec.SecurityContext.SeccompProfile) {
+			violations = append(violations, "pod must not set seccompProfile.type=Unconfined")
+		}
+	}
+	for _, container := range podContainers(spec) {
+		for _, port := range container.Ports {
+			if port.HostPort != 0 {
+				violations = append(violations, fmt.Sprintf("container %q must not set hostPort", container.Name))
+				break
+			}
+		}
+		sc := container.SecurityContext
+		if sc == nil {
+			continue
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			violations = append(violations, fmt.Sprintf("container %q must not set privileged=true", container.Name))
+		}
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				if !baselineCapabilities[capability] {
+					violations = append(violations, fmt.Sprintf("container %q must not add capability %s", container.Name, capability))
+				}
+			}
+		}
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			violations = append(violations, fmt.Sprintf("container %q must not set procMount=%s", container.Name, *sc.ProcMount))
+		}
+		if seccompUnconfined(sc.SeccompProfile) {
+			violations = append(violations, fmt.Sprintf("container %q must not set seccompProfile.type=Unconfined", container.Name))
+		}
+	}
+	if profile != myAppsv1.SecurityProfileRestricted {
+		return violations
+	}
+
+	// 2. restricted 等级
+	for _, volume := range spec.Volumes {
+		switch {
+		case volume.ConfigMap != nil, volume.CSI != nil, volume.DownwardAPI != nil, volume.EmptyDir != nil,
+			volume.Ephemeral != nil, volume.PersistentVolumeClaim != nil, volume.Projected != nil, volume.Secret != nil:
+		case volume.HostPath != nil:
+			// baseline 已经检查过
+		default:
+			violations = append(violations, fmt.Sprintf("volume %q uses a volume type that is not allowed", volume.Name))
+		}
+	}
+	podSC := spec.SecurityContext
+	if podSC == nil {
+		podSC = &corev1.PodSecurityContext{}
+	}
+	if podSC.RunAsUser != nil && *podSC.RunAsUser == 0 {
+		violations = append(violations, "pod must not set runAsUser=0")
+	}
+	for _, container := range podContainers(spec) {
+		sc := container.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			violations = append(violations, fmt.Sprintf("container %q must set allowPrivilegeEscalation=false", container.Name))
+		}
+		// 容器的配置优先于 pod 的配置
+		runAsNonRoot := podSC.RunAsNonRoot
+		if sc.RunAsNonRoot != nil {
+			runAsNonRoot = sc.RunAsNonRoot
+		}
+		if runAsNonRoot == nil || !*runAsNonRoot {
+			violations = append(violations, fmt.Sprintf("container %q must set runAsNonRoot=true", container.Name))
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			violations = append(violations, fmt.Sprintf("container %q must not set runAsUser=0", container.Name))
+		}
+		seccomp := podSC.SeccompProfile
+		if sc.SeccompProfile != nil {
+			seccomp = sc.SeccompProfile
+		}
+		if seccomp == nil || (seccomp.Type != corev1.SeccompProfileTypeRuntimeDefault && seccomp.Type != corev1.SeccompProfileTypeLocalhost) {
+			violations = append(violations, fmt.Sprintf("container %q must set seccompProfile.type to RuntimeDefault or Localhost", container.Name))
+		}
+		dropAll := false
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Drop {
+				if capability == "ALL" {
+					dropAll = true
+				}
+			}
+			for _, capability := range sc.Capabilities.Add {
+				// baseline 不允许的 capabilities 已经检查过
+				if capability != "NET_BIND_SERVICE" && baselineCapabilities[capability] {
+					violations = append(violations, fmt.Sprintf("container %q must only add capability NET_BIND_SERVICE", container.Name))
+					break
+				}
+			}
+		}
+		if !dropAll {
+			violations = append(violations, fmt.Sprintf("container %q must drop capability ALL", container.Name))
+		}
+	}
+	return violations
+}
+
+// seccompUnconfined seccomp 配置是否为 Unconfined
+func seccompUnconfined(profile *corev1.SeccompProfile) bool {
+	return profile != nil && profile.Type == corev1.SeccompProfileTypeUnconfined
+}
+
+// serviceAccountName pod 使用的 ServiceAccount 名称, 没有填写时为空, 使用 namespace 的 default
+func serviceAccountName(md *myAppsv1.ZwhDeployment) string {
+	sa := md.Spec.ServiceAccount
+	if sa == nil {
+		return ""
+	}
+	if sa.Create {
+		return md.Name
+	}
+	return sa.Name
+}
+
+// automountToken pod 是否挂载 ServiceAccount 的 token, 为 nil 时使用 ServiceAccount 自己的配置
+func automountToken(md *myAppsv1.ZwhDeployment) *bool {
+	sa := md.Spec.ServiceAccount
+	if sa == nil {
+		return nil
+	}
+	if sa.AutomountToken != nil {
+		automount := *sa.AutomountToken
+		return &automount
+	}
+	if !sa.Create {
+		return nil
+	}
+	// 没有授予权限时应用不需要访问 apiserver
+	automount := len(rbacRules(md)) > 0
+	return &automount
+}
+
+// rbacRules 授予 ServiceAccount 的权限
+func rbacRules(md *myAppsv1.ZwhDeployment) []rbacv1.PolicyRule {
+	if md.Spec.RBAC == nil || serviceAccountName(md) == "" {
+		return nil
+	}
+	return md.Spec.RBAC.Rules
+}
+
+// NewServiceAccount 生成 operator 管理的 ServiceAccount, 没有开启 create 时返回 nil
+func NewServiceAccount(md *myAppsv1.ZwhDeployment) *corev1.ServiceAccount {
+	if md.Spec.ServiceAccount == nil || !md.Spec.ServiceAccount.Create {
+		return nil
+	}
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      md.Name,
+			Namespace: md.Namespace,
+			Labels:    map[string]string{"app": md.Name},
+		},
+		AutomountServiceAccountToken: automountToken(md),
+	}
+}
+
+// NewRole 生成授予 ServiceAccount 权限的 Role, 没有填写 rbac.rules 时返回 nil
+func NewRole(md *myAppsv1.ZwhDeployment) *rbacv1.Role {
+	rules := rbacRules(md)
+	if len(rules) == 0 {
+		return nil
+	}
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      md.Name,
+			Namespace: md.Namespace,
+			Labels:    map[string]string{"app": md.Name},
+		},
+	}
+	for i := range rules {
+		role.Rules = append(role.Rules, *rules[i].DeepCopy())
+	}
+	return role
+}
+
+// NewRoleBinding 把同名的 Role 绑定到 ServiceAccount, 没有填写 rbac.rules 时返回 nil
+func NewRoleBinding(md *myAppsv1.ZwhDeployment) *rbacv1.RoleBinding {
+	if len(rbacRules(md)) == 0 {
+		return nil
+	}
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      md.Name,
+			Namespace: md.Namespace,
+			Labels:    map[string]string{"app": md.Name},
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: md.Name},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      serviceAccountName(md),
+			Namespace: md.Namespace,
+		}},
+	}
+}
+
+// NewNetworkPolicy 生成只允许声明的来源访问的 NetworkPolicy, ingress 和 egress 都没有填写时返回 nil
+// 填写了 ingress 时入口只开放 service 暴露的端口, 填写了 egress 时自动允许访问 DNS
+// 没有填写的方向不添加到 policyTypes 中, 不限制对应方向的流量
+func NewNetworkPolicy(md *myAppsv1.ZwhDeployment) *networkv1.NetworkPolicy {
+	policy := md.Spec.NetworkPolicy
+	// policyTypes 为空时 apiserver 默认为 Ingress, 会拒绝所有入口流量
+	if policy == nil || (len(policy.Ingress) == 0 && len(policy.Egress) == 0) {
+		return nil
+	}
+	np := &networkv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      md.Name,
+			Namespace: md.Namespace,
+			Labels:    map[string]string{"app": md.Name},
+		},
+		Spec: networkv1.NetworkPolicySpec{
+			PodSelector: appSelector(md.Name),
+		},
+	}
+	// 1. 入口流量
+	if len(policy.Ingress) > 0 {
+		np.Spec.PolicyTypes = append(np.Spec.PolicyTypes, networkv1.PolicyTypeIngress)
+		rule := networkv1.NetworkPolicyIngressRule{}
+		for _, port := range containerPorts(md) {
+			if !portExposed(port) {
+				continue
+			}
+			rule.Ports = append(rule.Ports, networkPolicyPort(port.ContainerPort, port.Protocol))
+		}
+		for _, peer := range policy.Ingress {
+			rule.From = append(rule.From, networkPolicyPeer(md, peer))
+		}
+		np.Spec.Ingress = []networkv1.NetworkPolicyIngressRule{rule}
+	}
+	// 2. 出口流量
+	if len(policy.Egress) > 0 {
+		np.Spec.PolicyTypes = append(np.Spec.PolicyTypes, networkv1.PolicyTypeEgress)
+		np.Spec.Egress = append(np.Spec.Egress, networkv1.NetworkPolicyEgressRule{
+			Ports: []networkv1.NetworkPolicyPort{networkPolicyPort(53, corev1.ProtocolUDP), networkPolicyPort(53, corev1.ProtocolTCP)},
+			To:    []networkv1.NetworkPolicyPeer{{NamespaceSelector: namespaceSelector(metav1.NamespaceSystem)}},
+		})
+		for _, egress := range policy.Egress {
+			rule := networkv1.NetworkPolicyEgressRule{To: []networkv1.NetworkPolicyPeer{networkPolicyPeer(md, egress.NetworkPolicyPeer)}}
+			for _, port := range egress.Ports {
+				rule.Ports = append(rule.Ports, networkPolicyPort(port.Port, port.Protocol))
+			}
+			np.Spec.Egress = append(np.Spec.Egress, rule)
+		}
+	}
+	return np
+}
+
+// appSelector 选择 ZwhDeployment 的 pod, 包括金丝雀发布和蓝绿发布的 pod
+func appSelector(name string) metav1.LabelSelector {
+	return metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+		Key:      "app",
+		Operator: metav1.LabelSelectorOpIn,
+		Values:   []string{name, name + "-canary", name + "-preview"},
+	}}}
+}
+
+// namespaceSelector 按照名称选择 namespace, kubernetes.io/metadata.name 标签由 apiserver 自动添加
+func namespaceSelector(namespace string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: namespace}}
+}
+
+// networkPolicyPeer 把 networkPolicy 中的来源或目标转换为 NetworkPolicy 中的定义
+func networkPolicyPeer(md *myAppsv1.ZwhDeployment, peer myAppsv1.NetworkPolicyPeer) networkv1.NetworkPolicyPeer {
+	switch {
+	case peer.IngressController:
+		namespace := md.Spec.NetworkPolicy.IngressControllerNamespace
+		if namespace == "" {
+			namespace = myAppsv1.DefaultIngressControllerNamespace
+		}
+		return networkv1.NetworkPolicyPeer{NamespaceSelector: namespaceSelector(namespace)}
+	case peer.CIDR != "":
+		return networkv1.NetworkPolicyPeer{IPBlock: &networkv1.IPBlock{CIDR: peer.CIDR, Except: peer.Except}}
+	}
+	result := networkv1.NetworkPolicyPeer{}
+	if peer.ZwhDeployment != "" {
+		selector := appSelector(peer.ZwhDeployment)
+		result.PodSelector = &selector
+	}
+	if peer.Namespace != "" {
+		result.NamespaceSelector = namespaceSelector(peer.Namespace)
+	}
+	return result
+}
+
+func networkPolicyPort(port int32, protocol corev1.Protocol) networkv1.NetworkPolicyPort {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	number := intstr.FromInt(int(port))
+	return networkv1.NetworkPolicyPort{Protocol: &protocol, Port: &number}
+}
+
+// containerPorts 获取容器的所有端口并补齐默认值
+// 没有填写 ports 时, 由 port 生成一个名为 http 的端口, service 的端口和节点端口使用 expose 中的配置
+func containerPorts(md *myAppsv1.ZwhDeployment) []myAppsv1.ContainerPort {
+	ports := md.Spec.Ports
+	if len(ports) == 0 {
+		ports = []myAppsv1.ContainerPort{{Name: myAppsv1.DefaultPortName, ContainerPort: md.Spec.Port}}
+	}
+	result := make([]myAppsv1.ContainerPort, 0, len(ports))
+	for _, port := range ports {
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		result = append(result, port)
+	}
+	return result
+}
+
+// primaryPort 获取主端口, port 对应的端口或者第一个端口
+func primaryPort(md *myAppsv1.ZwhDeployment) myAppsv1.ContainerPort {
+	ports := containerPorts(md)
+	for _, port := range ports {
+		if port.ContainerPort == md.Spec.Port {
+			return port
+		}
+	}
+	return ports[0]
+}
+
+// portExposed 判断端口是否通过 service 暴露,默认暴露
+func portExposed(port myAppsv1.ContainerPort) bool {
+	return port.Exposed == nil || *port.Exposed
+}
+
+// servicePortNumber 获取端口在 service 上的端口号, 主端口使用 expose 中的 servicePort
+func servicePortNumber(md *myAppsv1.ZwhDeployment, port myAppsv1.ContainerPort) int32 {
+	if port.Name == primaryPort(md).Name && md.Spec.Expose.ServicePort != 0 {
+		return md.Spec.Expose.ServicePort
+	}
+	return port.ContainerPort
+}
+
+// newContainerPorts 生成容器的端口, 不暴露的端口也需要声明
+func newContainerPorts(md *myAppsv1.ZwhDeployment) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for _, port := range containerPorts(md) {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          port.Name,
+			ContainerPort: port.ContainerPort,
+			Protocol:      port.Protocol,
+		})
+	}
+	return ports
+}
+
+// newServicePorts 生成 service 的端口, 通过名称选择容器端口
+// nodePort 为 true 时设置节点端口, 主端口没有填写时使用 expose 中的 nodePort
+func newServicePorts(md *myAppsv1.ZwhDeployment, nodePort bool) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	primary := primaryPort(md)
+	for _, port := range containerPorts(md) {
+		if !portExposed(port) {
+			continue
+		}
+		servicePort := corev1.ServicePort{
+			Name:       port.Name,
+			Protocol:   port.Protocol,
+			Port:       servicePortNumber(md, port),
+			TargetPort: intstr.FromString(port.Name),
+		}
+		if port.AppProtocol != "" {
+			appProtocol := port.AppProtocol
+			servicePort.AppProtocol = &appProtocol
+		}
+		if nodePort {
+			servicePort.NodePort = port.NodePort
+			if servicePort.NodePort == 0 && port.Name == primary.Name {
+				servicePort.NodePort = md.Spec.Expose.NodePort
+			}
+		}
+		ports = append(ports, servicePort)
+	}
+	return ports
+}
+
+// configHashAnnotation pod 模板上记录配置哈希的注解
+const configHashAnnotation = "apps.zwh.com/config-hash"
+
+// defaultConfigMountPath config.files 默认的挂载目录
+const defaultConfigMountPath = "/etc/config"
+
+// NewConfigMap 根据 config 生成 configmap, data 和 files 放在同一个 configmap 中
+// 没有填写 config 时返回 nil
+func NewConfigMap(md *myAppsv1.ZwhDeployment) *corev1.ConfigMap {
+	config := md.Spec.Config
+	if config == nil {
+		return nil
+	}
+	data := make(map[string]string, len(config.Data)+len(config.Files))
+	for k, v := range config.Data {
+		data[k] = v
+	}
+	for k, v := range config.Files {
+		data[k] = v
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(md),
+			Namespace: md.Namespace,
+			Labels:    map[string]string{"app": md.Name},
+		},
+		Data: data,
+	}
+}
+
+// configMapName operator 创建的 configmap 名称为 <md名称>-config
+func configMapName(md *myAppsv1.ZwhDeployment) string {
+	return md.Name + "-config"
+}
+
+// newEnv 生成主容器的环境变量
+// config.data 通过 configMapKeyRef 引用, 没有填写挂载目录的 secret 整个注入
+func newEnv(md *myAppsv1.ZwhDeployment) ([]corev1.EnvVar, []corev1.EnvFromSource) {
+	var env []corev1.EnvVar
+	if md.Spec.Config != nil {
+		for _, key := range sortedKeys(md.Spec.Config.Data) {
+			env = append(env, corev1.EnvVar{
+				Name: key,
+				ValueFrom: &corev1.EnvVarSource{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: configMapName(md)},
+						Key:                  key,
+					},
+				},
+			})
+		}
+	}
+	// 直接填写的环境变量在后面, 同名时覆盖 config 中的值
+	for i := range md.Spec.Environments {
+		env = append(env, *md.Spec.Environments[i].DeepCopy())
+	}
+	var envFrom []corev1.EnvFromSource
+	for i := range md.Spec.EnvFrom {
+		envFrom = append(envFrom, *md.Spec.EnvFrom[i].DeepCopy())
+	}
+	for _, ref := range md.Spec.SecretRefs {
+		if ref.MountPath != "" {
+			continue
+		}
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+			},
+		})
+	}
+	return env, envFrom
+}
+
+// newConfigVolumes 生成 config.files 和填写了挂载目录的 secret 对应的存储卷, 都以只读的方式挂载
+func newConfigVolumes(md *myAppsv1.ZwhDeployment) ([]corev1.Volume, []corev1.VolumeMount) {
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	if config := md.Spec.Config; config != nil && len(config.Files) > 0 {
+		var items []corev1.KeyToPath
+		for _, key := range sortedKeys(config.Files) {
+			items = append(items, corev1.KeyToPath{Key: key, Path: key})
+		}
+		mountPath := config.MountPath
+		if mountPath == "" {
+			mountPath = defaultConfigMountPath
+		}
+		volumes = append(volumes, corev1.Volume{
+			Name: "zwh-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: configMapName(md)},
+					Items:                items,
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: "zwh-config", MountPath: mountPath, ReadOnly: true})
+	}
+	for i, ref := range md.Spec.SecretRefs {
+		if ref.MountPath == "" {
+			continue
+		}
+		name := fmt.Sprintf("zwh-secret-%d", i)
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: ref.Name},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: name, MountPath: ref.MountPath, ReadOnly: true})
+	}
+	return volumes, mounts
+}
+
+// configRef pod 引用的一个已有的 configmap 或 secret
+type configRef struct {
+	kind     string
+	name     string
+	optional bool
+}
+
+// pinnedImage imagePolicy 为 pinDigest 时, 已经解析过的镜像使用带摘要的地址
+func pinnedImage(md *myAppsv1.ZwhDeployment, image string) string {
+	if md.Spec.ImagePolicy != myAppsv1.ImagePolicyPinDigest {
+		return image
+	}
+	for _, entry := range md.Status.Images {
+		if entry.Image == image {
+			return pinImage(image, entry.Digest)
+		}
+	}
+	return image
+}
+
+// deployedImage deployment 主容器使用的镜像, 带摘要的地址还原为 spec 中填写的镜像, 用来和 spec.image 比较
+func deployedImage(md *myAppsv1.ZwhDeployment, deploy *appsv1.Deployment) string {
+	image := deploy.Spec.Template.Spec.Containers[0].Image
+	for _, entry := range md.Status.Images {
+		if pinImage(entry.Image, entry.Digest) == image {
+			return entry.Image
+		}
+	}
+	return image
+}
+
+// imageDigest 获取 spec.image 已经解析过的摘要
+func imageDigest(md *myAppsv1.ZwhDeployment) (string, bool) {
+	for _, entry := range md.Status.Images {
+		if entry.Image == md.Spec.Image {
+			return entry.Digest, true
+		}
+	}
+	return "", false
+}
+
+// recordImageDigest 记录 spec.image 解析出的摘要
+// 上一个镜像和发布中稳定版本仍在使用的镜像需要保留, 否则稳定版本的 pod 模板会变成 tag 导致滚动更新
+func recordImageDigest(md *myAppsv1.ZwhDeployment, digest string, now metav1.Time) {
+	inUse := map[string]bool{}
+	if canary := md.Status.Canary; canary != nil {
+		inUse[canary.StableImage], inUse[canary.CanaryImage] = true, true
+	}
+	if blueGreen := md.Status.BlueGreen; blueGreen != nil {
+		inUse[blueGreen.ActiveImage], inUse[blueGreen.PreviewImage] = true, true
+	}
+	images := []myAppsv1.ImageDigest{{Image: md.Spec.Image, Digest: digest, ResolvedTime: &now}}
+	for i, entry := range md.Status.Images {
+		if entry.Image != md.Spec.Image && (i == 0 || inUse[entry.Image]) {
+			images = append(images, entry)
+		}
+	}
+	md.Status.Images = images
+}
+
+// registrySourceAnnotation 复制的镜像仓库凭证上记录来源的注解
+const registrySourceAnnotation = "apps.zwh.com/registry-source"
+
+// registrySecretName 复制到当前 namespace 的镜像仓库凭证名称
+func registrySecretName(md *myAppsv1.ZwhDeployment) string {
+	return fmt.Sprintf("%s-registry", md.Name)
+}
+
+// imagePullSecrets pod 拉取镜像使用的 secret, 包括复制的镜像仓库凭证
+func imagePullSecrets(md *myAppsv1.ZwhDeployment) []corev1.LocalObjectReference {
+	var secrets []corev1.LocalObjectReference
+	secrets = append(secrets, md.Spec.ImagePullSecrets...)
+	if md.Spec.RegistryCredentials != nil {
+		secrets = append(secrets, corev1.LocalObjectReference{Name: registrySecretName(md)})
+	}
+	return secrets
+}
+
+// NewRegistrySecret 把集中管理的镜像仓库凭证复制到 ZwhDeployment 的 namespace
+func NewRegistrySecret(md *myAppsv1.ZwhDeployment, source *corev1.Secret) *corev1.Secret {
+	data := make(map[string][]byte, len(source.Data))
+	for k, v := range source.Data {
+		data[k] = append([]byte(nil), v...)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        registrySecretName(md),
+			Namespace:   md.Namespace,
+			Labels:      map[string]string{"app": md.Name},
+			Annotations: map[string]string{registrySourceAnnotation: source.Namespace + "/" + source.Name},
+		},
+		Type: source.Type,
+		Data: data,
+	}
+}
+
+// registryCredentialsValid 只有 docker 配置类型的 secret 可以用来拉取镜像
+func registryCredentialsValid(source *corev1.Secret) bool {
+	return source.Type == corev1.SecretTypeDockerConfigJson || source.Type == corev1.SecretTypeDockercfg
+}
+
+// referencedConfig 获取 pod 引用的已有 configmap 和 secret, 不包括 operator 生成的 configmap
+func referencedConfig(md *myAppsv1.ZwhDeployment) []configRef {
+	var refs []configRef
+	for _, ref := range md.Spec.SecretRefs {
+		refs = append(refs, configRef{kind: "Secret", name: ref.Name})
+	}
+	for _, source := range md.Spec.EnvFrom {
+		if source.ConfigMapRef != nil {
+			refs = append(refs, configRef{
+				kind:     "ConfigMap",
+				name:     source.ConfigMapRef.Name,
+				optional: source.ConfigMapRef.Optional != nil && *source.ConfigMapRef.Optional,
+			})
+		}
+		if source.SecretRef != nil {
+			refs = append(refs, configRef{
+				kind:     "Secret",
+				name:     source.SecretRef.Name,
+				optional: source.SecretRef.Optional != nil && *source.SecretRef.Optional,
+			})
+		}
+	}
+	return refs
+}
+
+// configRefKeys 生成 md 引用的已有 configmap 和 secret 的索引值, 格式为 <kind>/<name>
+func configRefKeys(md *myAppsv1.ZwhDeployment) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, ref := range referencedConfig(md) {
+		key := ref.kind + "/" + ref.name
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// configHash 计算 pod 使用的 configmap 和 secret 的内容哈希
+// 按照传入的顺序计算, 键按照字典序, 任意一个内容变化时哈希都会变化
+func configHash(configMaps []*corev1.ConfigMap, secrets []*corev1.Secret) string {
+	h := sha256.New()
+	for _, cm := range configMaps {
+		fmt.Fprintf(h, "configmap/%s\n", cm.Name)
+		for _, key := range sortedKeys(cm.Data) {
+			fmt.Fprintf(h, "%s=%q\n", key, cm.Data[key])
+		}
+		for _, key := range sortedKeys(cm.BinaryData) {
+			fmt.Fprintf(h, "%s=%q\n", key, cm.BinaryData[key])
+		}
+	}
+	for _, secret := range secrets {
+		fmt.Fprintf(h, "secret/%s\n", secret.Name)
+		for _, key := range sortedKeys(secret.Data) {
+			fmt.Fprintf(h, "%s=%q\n", key, secret.Data[key])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// revisionLabel ControllerRevision 上记录所属 md 名称的标签, revisionAppliedAnnotation 记录这个版本最近一次生效的时间
+const (
+	revisionLabel             = "apps.zwh.com/zwhdeployment"
+	revisionAppliedAnnotation = "apps.zwh.com/applied-at"
+)
+
+// revisionData ControllerRevision 中保存的 spec 和渲染出的镜像
+// imagePolicy 为 pinDigest 时镜像为带摘要的地址, 回滚时使用相同的摘要
+type revisionData struct {
+	Spec   myAppsv1.ZwhDeploymentSpec `json:"spec"`
+	Images []string                   `json:"images"`
+}
+
+// NewControllerRevision 生成保存当前 spec 和 pod 模板中镜像的 ControllerRevision, 名称为 <md名称>-<内容哈希>
+// 相同的 spec 和镜像生成相同的名称, 回滚到之前的 spec 时复用已有的版本
+func NewControllerRevision(md *myAppsv1.ZwhDeployment, template *corev1.PodTemplateSpec, revision int64) (*appsv1.ControllerRevision, error) {
+	data, err := json.Marshal(revisionData{Spec: md.Spec, Images: podImages(&template.Spec)})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", md.Name, hex.EncodeToString(sum[:])[:10]),
+			Namespace: md.Namespace,
+			Labels:    map[string]string{revisionLabel: md.Name},
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: revision,
+	}, nil
+}
+
+// readRevision 获取 ControllerRevision 中保存的 spec 和镜像
+func readRevision(rev *appsv1.ControllerRevision) (*revisionData, error) {
+	data := new(revisionData)
+	if err := json.Unmarshal(rev.Data.Raw, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// imageDigest 获取版本中 spec.image 固定的摘要, 没有固定时返回空字符串
+func (d *revisionData) imageDigest() string {
+	for _, image := range d.Images {
+		if digest, ok := strings.CutPrefix(image, d.Spec.Image+"@"); ok {
+			return digest
+		}
+	}
+	return ""
+}
+
+// podImages 获取 pod 模板中所有容器实际使用的镜像, 顺序为初始化容器、主容器、边车容器
+func podImages(spec *corev1.PodSpec) []string {
+	containers := podContainers(spec)
+	images := make([]string, 0, len(containers))
+	for _, c := range containers {
+		images = append(images, c.Image)
+	}
+	return images
+}
+
+// revisionHistoryLimit 获取保留的历史版本数量, 默认为10
+func revisionHistoryLimit(md *myAppsv1.ZwhDeployment) int {
+	if md.Spec.RevisionHistoryLimit == nil {
+		return 10
+	}
+	return int(*md.Spec.RevisionHistoryLimit)
+}
+
+// revisionsToPrune 获取超过 limit 需要删除的旧版本, 版本号从小到大删除, 当前版本不删除
+func revisionsToPrune(revisions []appsv1.ControllerRevision, limit int, current string) []appsv1.ControllerRevision {
+	if len(revisions) <= limit {
+		return nil
+	}
+	sorted := make([]appsv1.ControllerRevision, len(revisions))
+	copy(sorted, revisions)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Revision < sorted[j].Revision })
+	var prune []appsv1.ControllerRevision
+	for _, rev := range sorted {
+		if len(revisions)-len(prune) <= limit {
+			break
+		}
+		if rev.Name != current {
+			prune = append(prune, rev)
+		}
+	}
+	return prune
+}
+
+// sortedKeys 按照字典序获取 map 的键, 保证生成的对象顺序稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// workloadKind 获取工作负载类型,默认为 Deployment
+func workloadKind(md *myAppsv1.ZwhDeployment) string {
+	if strings.EqualFold(md.Spec.WorkloadKind, myAppsv1.WorkloadKindStatefulSet) {
+		return myAppsv1.WorkloadKindStatefulSet
+	}
+	return myAppsv1.WorkloadKindDeployment
+}
+
+// newVolumes 合并 volumes/volumeMounts 和 storage 中 pvc 对应的存储卷
+func newVolumes(md *myAppsv1.ZwhDeployment) ([]corev1.Volume, []corev1.VolumeMount) {
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	for i := range md.Spec.Volumes {
+		volumes = append(volumes, *md.Spec.Volumes[i].DeepCopy())
+	}
+	for i := range md.Spec.VolumeMounts {
+		mounts = append(mounts, *md.Spec.VolumeMounts[i].DeepCopy())
+	}
+	if md.Spec.Storage == nil {
+		return volumes, mounts
+	}
+	for _, claim := range md.Spec.Storage.Claims {
+		if claim.MountPath != "" {
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      claim.Name,
+				MountPath: claim.MountPath,
+			})
+		}
+		// statefulset 的存储卷由 volumeClaimTemplates 提供
+		if workloadKind(md) == myAppsv1.WorkloadKindStatefulSet {
+			continue
+		}
+		volumes = append(volumes, corev1.Volume{
+			Name: claim.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: pvcName(md, claim.Name),
+				},
+			},
+		})
+	}
+	return volumes, mounts
+}
+
+// NewPVCs 根据 storage 生成由 operator 管理的 pvc
+func NewPVCs(md *myAppsv1.ZwhDeployment) []*corev1.PersistentVolumeClaim {
+	if md.Spec.Storage == nil {
+		return nil
+	}
+	pvcs := make([]*corev1.PersistentVolumeClaim, 0, len(md.Spec.Storage.Claims))
+	for _, claim := range md.Spec.Storage.Claims {
+		pvcs = append(pvcs, &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        pvcName(md, claim.Name),
+				Namespace:   md.Namespace,
+				Labels:      map[string]string{"app": md.Name},
+				Annotations: map[string]string{myAppsv1.AnnotationStorageOwner: md.Name},
+			},
+			Spec: newPVCSpec(claim),
+		})
+	}
+	return pvcs
+}
+
+// newPVCSpec 根据 storage 中的配置生成 pvc 的 spec
+func newPVCSpec(claim myAppsv1.StorageClaim) corev1.PersistentVolumeClaimSpec {
+	accessModes := claim.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	spec := corev1.PersistentVolumeClaimSpec{
+		AccessModes: accessModes,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: claim.Size.DeepCopy(),
+			},
+		},
+	}
+	if claim.StorageClassName != "" {
+		storageClassName := claim.StorageClassName
+		spec.StorageClassName = &storageClassName
+	}
+	return spec
+}
+
+// pvcName operator 创建的 pvc 名称为 <md名称>-<存储卷名称>
+func pvcName(md *myAppsv1.ZwhDeployment, name string) string {
+	return fmt.Sprintf("%s-%s", md.Name, name)
+}
+
+// pvcManaged 判断已有的 pvc 是否由 operator 为 md 创建
+// reclaimPolicy 为 retain 时 pvc 没有 controller 引用, 根据注解判断, md 删除后重新创建时可以继续使用保留的 pvc
+func pvcManaged(md *myAppsv1.ZwhDeployment, pvc *corev1.PersistentVolumeClaim) bool {
+	if metav1.IsControlledBy(pvc, md) {
+		return true
+	}
+	return metav1.GetControllerOf(pvc) == nil && pvc.Annotations[myAppsv1.AnnotationStorageOwner] == md.Name
+}
+
+// pvcReclaimDelete 判断 md 删除时是否需要一起删除 pvc
+func pvcReclaimDelete(md *myAppsv1.ZwhDeployment) bool {
+	return md.Spec.Storage != nil &&
+		strings.ToLower(md.Spec.Storage.ReclaimPolicy) == myAppsv1.StorageReclaimDelete
+}
+
+// newProbes 生成容器的 liveness, readiness, startup 检查
+// 都没有填写时,默认对主端口做 tcp 检查
+func newProbes(md *myAppsv1.ZwhDeployment) (liveness, readiness, startup *corev1.Probe) {
+	probes := md.Spec.Probes
+	port := primaryPort(md).ContainerPort
+	if probes == nil || (probes.Liveness == nil && probes.Readiness == nil && probes.Startup == nil) {
+		tcp := corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(int(port))},
+		}
+		liveness = &corev1.Probe{ProbeHandler: tcp, InitialDelaySeconds: 15, PeriodSeconds: 20}
+		readiness = &corev1.Probe{ProbeHandler: *tcp.DeepCopy(), InitialDelaySeconds: 5, PeriodSeconds: 10}
+		return liveness, readiness, nil
+	}
+	return withDefaultPort(probes.Liveness, port),
+		withDefaultPort(probes.Readiness, port),
+		withDefaultPort(probes.Startup, port)
+}
+
+// withDefaultPort 复制一份 probe, 没有填写端口的检查使用 port
+func withDefaultPort(probe *corev1.Probe, port int32) *corev1.Probe {
+	if probe == nil {
+		return nil
+	}
+	probe = probe.DeepCopy()
+	if probe.HTTPGet != nil && probe.HTTPGet.Port == (intstr.IntOrString{}) {
+		probe.HTTPGet.Port = intstr.FromInt(int(port))
+	}
+	if probe.TCPSocket != nil && probe.TCPSocket.Port == (intstr.IntOrString{}) {
+		probe.TCPSocket.Port = intstr.FromInt(int(port))
+	}
+	if probe.GRPC != nil && probe.GRPC.Port == 0 {
+		probe.GRPC.Port = port
+	}
+	return probe
+}
+
+// sizePresets size 预设对应的 requests 和 limits
+var sizePresets = map[string]corev1.ResourceRequirements{
+	myAppsv1.SizeSmall: {
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+	},
+	myAppsv1.SizeMedium: {
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("250m"),
+			corev1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	},
+	myAppsv1.SizeLarge: {
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+	},
+}
+
+// newResources 根据 size 预设和 resources 计算容器最终的资源,resources 中填写的资源优先
+func newResources(md *myAppsv1.ZwhDeployment) (corev1.ResourceRequirements, error) {
+	resources := corev1.ResourceRequirements{}
+	if md.Spec.Size != "" {
+		preset, ok := sizePresets[strings.ToLower(md.Spec.Size)]
+		if !ok {
+			return resources, myAppsv1.ErrorNotSupportSize
+		}
+		resources = *preset.DeepCopy()
+	}
+	if md.Spec.Resources == nil {
+		return resources, nil
+	}
+	for name, quantity := range md.Spec.Resources.Requests {
+		if resources.Requests == nil {
+			resources.Requests = corev1.ResourceList{}
+		}
+		resources.Requests[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range md.Spec.Resources.Limits {
+		if resources.Limits == nil {
+			resources.Limits = corev1.ResourceList{}
+		}
+		resources.Limits[name] = quantity.DeepCopy()
+	}
+	resources.Claims = md.Spec.Resources.Claims
+	return resources, nil
+}
+
+// qosClass 按照 kubelet 的规则计算 pod 的 QoS 等级
+// 统计所有的容器和初始化容器, 只看 cpu 和 memory, 其他资源不影响 QoS 等级
+func qosClass(spec *corev1.PodSpec) corev1.PodQOSClass {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	guaranteed := true
+	for _, c := range podContainers(spec) {
+		found := 0
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, hasLimit := c.Resources.Limits[name]
+			request, hasRequest := c.Resources.Requests[name]
+			// 没有填写 requests 时, apiserver 会使用 limits 作为 requests
+			if !hasRequest && hasLimit {
+				request, hasRequest = limit, true
+			}
+			if hasRequest && !request.IsZero() {
+				quantity := requests[name]
+				quantity.Add(request)
+				requests[name] = quantity
+			}
+			if hasLimit && !limit.IsZero() {
+				quantity := limits[name]
+				quantity.Add(limit)
+				limits[name] = quantity
+				found++
+			}
+		}
+		// 任意一个容器没有同时限制 cpu 和 memory 时不是 Guaranteed
+		if found != 2 {
+			guaranteed = false
+		}
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return corev1.PodQOSBestEffort
+	}
+	if guaranteed {
+		for name, request := range requests {
+			if limit, ok := limits[name]; !ok || request.Cmp(limit) != 0 {
+				guaranteed = false
+			}
+		}
+	}
+	if guaranteed && len(requests) == len(limits) {
+		return corev1.PodQOSGuaranteed
+	}
+	return corev1.PodQOSBurstable
+}
+
 func NewIngress(md *myAppsv1.ZwhDeployment) (*networkv1.Ingress, error) {
+	rules, _ := splitIngressRules(md)
+	return buildIngress(md, md.Name, rules)
+}
+
+// NewRewriteIngress 生成需要重写路径的规则的 ingress, 只有部分规则填写了 rewriteTarget 时才需要, 否则返回 nil
+// rewrite-target 注解对整个 ingress 生效, 放到单独的 ingress 中避免重写其他规则的路径
+func NewRewriteIngress(md *myAppsv1.ZwhDeployment) (*networkv1.Ingress, error) {
+	_, rewrite := splitIngressRules(md)
+	if len(rewrite) == 0 {
+		return nil, nil
+	}
+	return buildIngress(md, rewriteIngressName(md), rewrite)
+}
+
+// rewriteIngressName 需要重写路径的规则的 ingress 名称
+func rewriteIngressName(md *myAppsv1.ZwhDeployment) string {
+	return md.Name + "-rewrite"
+}
+
+// splitIngressRules 把填写了 rewriteTarget 的规则和其他规则分开
+// 所有的规则都需要重写或者都不需要重写时, 全部放在稳定版本的 ingress 中
+func splitIngressRules(md *myAppsv1.ZwhDeployment) (rules, rewrite []myAppsv1.IngressRule) {
+	for _, rule := range ingressRules(md) {
+		if rule.RewriteTarget != "" {
+			rewrite = append(rewrite, rule)
+		} else {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return rewrite, nil
+	}
+	return rules, rewrite
+}
+
+// buildIngress 使用 rules 生成名称为 name 的 ingress, 规则填写了 rewriteTarget 时添加重写注解
+func buildIngress(md *myAppsv1.ZwhDeployment, name string, rules []myAppsv1.IngressRule) (*networkv1.Ingress, error) {
 	content, err := parseTemplate(md, "ingress.yaml")
 	if err != nil {
 		return nil, err
@@ -46,9 +1756,97 @@ func NewIngress(md *myAppsv1.ZwhDeployment) (*networkv1.Ingress, error) {
 	if err := yaml.Unmarshal(content, ig); err != nil {
 		return nil, err
 	}
+	ig.Name = name
+	// 相同域名的规则合并到一个 rule 中
+	hosts := ruleHosts(rules)
+	paths := map[string][]networkv1.HTTPIngressPath{}
+	rewriteTarget := ""
+	for _, rule := range rules {
+		pathType := networkv1.PathType(rule.PathType)
+		paths[rule.Host] = append(paths[rule.Host], networkv1.HTTPIngressPath{
+			Path:     rule.Path,
+			PathType: &pathType,
+			Backend: networkv1.IngressBackend{
+				Service: &networkv1.IngressServiceBackend{
+					Name: md.Name,
+					Port: networkv1.ServiceBackendPort{Name: rule.PortName},
+				},
+			},
+		})
+		if rule.RewriteTarget != "" {
+			rewriteTarget = rule.RewriteTarget
+		}
+	}
+	for _, host := range hosts {
+		ig.Spec.Rules = append(ig.Spec.Rules, networkv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkv1.IngressRuleValue{
+				HTTP: &networkv1.HTTPIngressRuleValue{Paths: paths[host]},
+			},
+		})
+	}
+	if tlsEnabled(md) {
+		ig.Spec.TLS = []networkv1.IngressTLS{{
+			Hosts:      hosts,
+			SecretName: tlsSecretName(md),
+		}}
+	}
+	if len(md.Spec.Expose.IngressAnnotations) > 0 || rewriteTarget != "" {
+		ig.Annotations = make(map[string]string, len(md.Spec.Expose.IngressAnnotations)+1)
+		for k, v := range md.Spec.Expose.IngressAnnotations {
+			ig.Annotations[k] = v
+		}
+		if rewriteTarget != "" {
+			ig.Annotations[rewriteTargetAnnotation] = rewriteTarget
+		}
+	}
 	return ig, nil
 }
 
+// rewriteTargetAnnotation ingress-nginx 重写路径的注解
+const rewriteTargetAnnotation = "nginx.ingress.kubernetes.io/rewrite-target"
+
+// ingressRules 获取 ingress 的转发规则并补齐默认值
+// 没有填写 rules 时, 把 ingressDomain 的 / 转发到服务
+func ingressRules(md *myAppsv1.ZwhDeployment) []myAppsv1.IngressRule {
+	rules := md.Spec.Expose.Rules
+	if len(rules) == 0 {
+		rules = []myAppsv1.IngressRule{{Host: md.Spec.Expose.IngressDomain}}
+	}
+	result := make([]myAppsv1.IngressRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Path == "" {
+			rule.Path = "/"
+		}
+		if rule.PathType == "" {
+			rule.PathType = string(networkv1.PathTypePrefix)
+		}
+		if rule.PortName == "" {
+			rule.PortName = primaryPort(md).Name
+		}
+		result = append(result, rule)
+	}
+	return result
+}
+
+// ingressHosts 按照规则的顺序获取不重复的域名, 证书需要包含所有的域名
+func ingressHosts(md *myAppsv1.ZwhDeployment) []string {
+	return ruleHosts(ingressRules(md))
+}
+
+// ruleHosts 按照规则的顺序获取不重复的域名
+func ruleHosts(rules []myAppsv1.IngressRule) []string {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if !seen[rule.Host] {
+			seen[rule.Host] = true
+			hosts = append(hosts, rule.Host)
+		}
+	}
+	return hosts
+}
+
 func NewService(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
 	content, err := parseTemplate(md, "service.yaml")
 	if err != nil {
@@ -58,13 +1856,122 @@ func NewService(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
 	if err := yaml.Unmarshal(content, svc); err != nil {
 		return nil, err
 	}
+	svc.Spec.Ports = newServicePorts(md, false)
+	setServiceAnnotations(md, svc)
+	setServiceSelector(md, svc)
+	return svc, nil
+}
+
+// NewServiceLB mode 为 loadbalancer 时使用, 负载均衡的 ip、网段和实现类在这里设置
+func NewServiceLB(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
+	content, err := parseTemplate(md, "service-lb.yaml")
+	if err != nil {
+		return nil, err
+	}
+	svc := new(corev1.Service)
+	if err := yaml.Unmarshal(content, svc); err != nil {
+		return nil, err
+	}
+	expose := md.Spec.Expose
+	svc.Spec.Ports = newServicePorts(md, false)
+	svc.Spec.LoadBalancerIP = expose.LoadBalancerIP
+	svc.Spec.LoadBalancerSourceRanges = expose.LoadBalancerSourceRanges
+	if expose.LoadBalancerClass != "" {
+		class := expose.LoadBalancerClass
+		svc.Spec.LoadBalancerClass = &class
+	}
+	setServiceAnnotations(md, svc)
+	setServiceSelector(md, svc)
+	return svc, nil
+}
+
+// setServiceAnnotations 把 expose 中的注解设置到 service 上
+func setServiceAnnotations(md *myAppsv1.ZwhDeployment, svc *corev1.Service) {
+	if len(md.Spec.Expose.ServiceAnnotations) == 0 {
+		return
+	}
+	svc.Annotations = make(map[string]string, len(md.Spec.Expose.ServiceAnnotations))
+	for k, v := range md.Spec.Expose.ServiceAnnotations {
+		svc.Annotations[k] = v
+	}
+}
+
+// lbAddress 获取负载均衡分配的外部地址, 还没有分配时返回空字符串
+func lbAddress(svc *corev1.Service) string {
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+	}
+	return ""
+}
+
+// containerStatuses 汇总所有 pod 中每个容器的重启次数和等待原因, 顺序和 pod 模板中的容器一致
+func containerStatuses(md *myAppsv1.ZwhDeployment, pods []corev1.Pod) []myAppsv1.ContainerStatus {
+	var names []string
+	for _, container := range md.Spec.InitContainers {
+		names = append(names, container.Name)
+	}
+	names = append(names, md.Name)
+	for _, container := range md.Spec.Sidecars {
+		names = append(names, container.Name)
+	}
+	statuses := make([]myAppsv1.ContainerStatus, len(names))
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		statuses[i].Name = name
+		index[name] = i
+	}
+	for i := range pods {
+		var podStatuses []corev1.ContainerStatus
+		podStatuses = append(podStatuses, pods[i].Status.InitContainerStatuses...)
+		podStatuses = append(podStatuses, pods[i].Status.ContainerStatuses...)
+		for _, cs := range podStatuses {
+			j, ok := index[cs.Name]
+			if !ok {
+				continue
+			}
+			statuses[j].RestartCount += cs.RestartCount
+			if statuses[j].WaitingReason == "" && cs.State.Waiting != nil {
+				statuses[j].WaitingReason = cs.State.Waiting.Reason
+			}
+		}
+	}
+	return statuses
+}
+
+// NewHeadlessService 生成 statefulset 使用的 headless service
+func NewHeadlessService(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
+	content, err := parseTemplate(md, "service-headless.yaml")
+	if err != nil {
+		return nil, err
+	}
+	svc := new(corev1.Service)
+	if err := yaml.Unmarshal(content, svc); err != nil {
+		return nil, err
+	}
+	// pod 之间直接访问, 端口号使用容器端口
+	for _, port := range containerPorts(md) {
+		if !portExposed(port) {
+			continue
+		}
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Name:       port.Name,
+			Protocol:   port.Protocol,
+			Port:       port.ContainerPort,
+			TargetPort: intstr.FromString(port.Name),
+		})
+	}
 	return svc, nil
 }
 
 // NewIssuer 实现创建issuer资源对象
+// 只有签发方式为 selfsigned 时才需要创建 issuer,其他情况返回 nil
 func NewIssuer(md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
-	if md.Spec.Expose.Mode != myAppsv1.ModeIngress ||
-		!md.Spec.Expose.Tls {
+	if !tlsEnabled(md) || tlsIssuer(md) != myAppsv1.TlsIssuerSelfSigned {
 		return nil, nil
 	}
 	// Sample
@@ -90,9 +1997,9 @@ func NewIssuer(md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
 }
 
 // NewCert 实现创建certificate资源
+// 签发方式为 secret 时证书由用户提供,不需要创建 certificate,返回 nil
 func NewCert(md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
-	if md.Spec.Expose.Mode != myAppsv1.ModeIngress ||
-		!md.Spec.Expose.Tls {
+	if !tlsEnabled(md) || tlsIssuer(md) == myAppsv1.TlsIssuerSecret {
 		return nil, nil
 	}
 	// Sample
@@ -103,11 +2010,22 @@ func NewCert(md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
 	//  namespace: system
 	//spec:
 	//  dnsNames:
-	//  - <spec.expose.ingressDomain>
+	//  - <spec.expose.ingressDomain 或 rules 中的所有域名>
 	//  issuerRef:
 	//    kind: Issuer
 	//    name: selfsigned-issuer
 	//  secretName: webhook-server-cert
+	issuerKind, issuerName := "Issuer", md.Name
+	switch tlsIssuer(md) {
+	case myAppsv1.TlsIssuerIssuer:
+		issuerName = md.Spec.Expose.Tls.IssuerName
+	case myAppsv1.TlsIssuerClusterIssuer:
+		issuerKind, issuerName = "ClusterIssuer", md.Spec.Expose.Tls.IssuerName
+	}
+	var dnsNames []interface{}
+	for _, host := range ingressHosts(md) {
+		dnsNames = append(dnsNames, host)
+	}
 	return &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": "cert-manager.io/v1",
@@ -117,21 +2035,251 @@ func NewCert(md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
 				"namespace": md.Namespace,
 			},
 			"spec": map[string]interface{}{
-				"dnsNames": []interface{}{
-					md.Spec.Expose.IngressDomain,
-				},
+				"dnsNames": dnsNames,
 				"issuerRef": map[string]interface{}{
-					"kind": "Issuer",
-					"name": md.Name,
+					"kind": issuerKind,
+					"name": issuerName,
+				},
+				"secretName": tlsSecretName(md),
+			},
+		},
+	}, nil
+}
+
+// NewHTTPRoute mode 为 gateway 时使用, 生成 Gateway API 的 HTTPRoute, 后端为普通 service
+func NewHTTPRoute(md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
+	gateway := md.Spec.Expose.Gateway
+	if gateway == nil {
+		return nil, fmt.Errorf("spec.expose.gateway is required when mode is %s", myAppsv1.ModeGateway)
+	}
+	// Sample
+	//apiVersion: gateway.networking.k8s.io/v1
+	//kind: HTTPRoute
+	//metadata:
+	//  name: <md.name>
+	//spec:
+	//  parentRefs:
+	//  - name: <gateway.name>
+	//  hostnames:
+	//  - <spec.expose.ingressDomain>
+	//  rules:
+	//  - matches:
+	//    - path:
+	//        type: PathPrefix
+	//        value: /
+	//    backendRefs:
+	//    - name: <md.name>
+	//      port: <servicePort>
+	parentRef := map[string]interface{}{
+		"name": gateway.Name,
+	}
+	if gateway.Namespace != "" {
+		parentRef["namespace"] = gateway.Namespace
+	}
+	if gateway.SectionName != "" {
+		parentRef["sectionName"] = gateway.SectionName
+	}
+	paths := gateway.Paths
+	if len(paths) == 0 {
+		paths = []myAppsv1.GatewayPath{{Value: "/"}}
+	}
+	var matches []interface{}
+	for _, path := range paths {
+		pathType := path.Type
+		if pathType == "" {
+			pathType = myAppsv1.GatewayPathPrefix
+		}
+		matches = append(matches, map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":  pathType,
+				"value": path.Value,
+			},
+		})
+	}
+	servicePort := servicePortNumber(md, primaryPort(md))
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": matches,
+				"backendRefs": []interface{}{
+					map[string]interface{}{
+						"name": md.Name,
+						"port": int64(servicePort),
+					},
 				},
-				"secretName": md.Name,
 			},
 		},
+	}
+	if hostnames := gatewayHostnames(md); len(hostnames) > 0 {
+		spec["hostnames"] = hostnames
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "gateway.networking.k8s.io/v1",
+			"kind":       "HTTPRoute",
+			"metadata": map[string]interface{}{
+				"name":      md.Name,
+				"namespace": md.Namespace,
+			},
+			"spec": spec,
+		},
 	}, nil
 }
 
+// gatewayHostnames 获取 HTTPRoute 匹配的域名, 没有填写时使用 ingressDomain
+func gatewayHostnames(md *myAppsv1.ZwhDeployment) []interface{} {
+	var hostnames []interface{}
+	for _, hostname := range md.Spec.Expose.Gateway.Hostnames {
+		hostnames = append(hostnames, hostname)
+	}
+	if len(hostnames) == 0 && md.Spec.Expose.IngressDomain != "" {
+		hostnames = append(hostnames, md.Spec.Expose.IngressDomain)
+	}
+	return hostnames
+}
+
+// routeCondition 汇总 HTTPRoute 在所有 parent 上的指定 condition
+// 只要有一个 parent 不是 True 就返回这个 parent 的状态, gateway 控制器还没有处理时 found 为 false
+func routeCondition(route *unstructured.Unstructured, conditionType string) (status, reason, message string, found bool) {
+	parents, _, _ := unstructured.NestedSlice(route.Object, "status", "parents")
+	for i := range parents {
+		parent, ok := parents[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditions, _, _ := unstructured.NestedSlice(parent, "conditions")
+		for j := range conditions {
+			condition, ok := conditions[j].(map[string]interface{})
+			if !ok || condition["type"] != conditionType {
+				continue
+			}
+			status, _ = condition["status"].(string)
+			reason, _ = condition["reason"].(string)
+			message, _ = condition["message"].(string)
+			found = true
+			if status != myAppsv1.ConditionStatusTrue {
+				return status, reason, message, found
+			}
+		}
+	}
+	return status, reason, message, found
+}
+
+// NewHPA 生成 autoscaling/v2 的 hpa, cpu 和内存目标在自定义指标之前
+func NewHPA(md *myAppsv1.ZwhDeployment) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	content, err := parseTemplate(md, "hpa.yaml")
+	if err != nil {
+		return nil, err
+	}
+	hpa := new(autoscalingv2.HorizontalPodAutoscaler)
+	if err := yaml.Unmarshal(content, hpa); err != nil {
+		return nil, err
+	}
+	autoscaling := md.Spec.Autoscaling
+	hpa.Spec.MinReplicas = minReplicas(md)
+	hpa.Spec.Metrics = append(hpa.Spec.Metrics,
+		resourceMetric(corev1.ResourceCPU, autoscaling.TargetCPUUtilizationPercentage)...)
+	hpa.Spec.Metrics = append(hpa.Spec.Metrics,
+		resourceMetric(corev1.ResourceMemory, autoscaling.TargetMemoryUtilizationPercentage)...)
+	hpa.Spec.Metrics = append(hpa.Spec.Metrics, autoscaling.Metrics...)
+	hpa.Spec.Behavior = autoscaling.Behavior
+	return hpa, nil
+}
+
+// resourceMetric 把使用率目标转换成 hpa 的 Resource 指标, 没有填写时返回空
+func resourceMetric(name corev1.ResourceName, utilization *int32) []autoscalingv2.MetricSpec {
+	if utilization == nil {
+		return nil
+	}
+	target := *utilization
+	return []autoscalingv2.MetricSpec{{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &target,
+			},
+		},
+	}}
+}
+
+// autoscalingEnabled 判断副本数是否交给 hpa 控制
+func autoscalingEnabled(md *myAppsv1.ZwhDeployment) bool {
+	return md.Spec.Autoscaling != nil && md.Spec.Autoscaling.Enable
+}
+
+// minReplicas 获取 hpa 的最小副本数,未填写时默认为1
+func minReplicas(md *myAppsv1.ZwhDeployment) *int32 {
+	replicas := int32(1)
+	if md.Spec.Autoscaling.MinReplicas != nil {
+		replicas = *md.Spec.Autoscaling.MinReplicas
+	}
+	return &replicas
+}
+
+// NewPDB 生成 pdb, minAvailable 和 maxUnavailable 都没有填写时默认 maxUnavailable 为1
+func NewPDB(md *myAppsv1.ZwhDeployment) (*policyv1.PodDisruptionBudget, error) {
+	content, err := parseTemplate(md, "pdb.yaml")
+	if err != nil {
+		return nil, err
+	}
+	pdb := new(policyv1.PodDisruptionBudget)
+	if err := yaml.Unmarshal(content, pdb); err != nil {
+		return nil, err
+	}
+	if budget := md.Spec.DisruptionBudget; budget != nil && budget.MinAvailable != nil {
+		minAvailable := *budget.MinAvailable
+		pdb.Spec.MinAvailable = &minAvailable
+	} else if budget != nil && budget.MaxUnavailable != nil {
+		maxUnavailable := *budget.MaxUnavailable
+		pdb.Spec.MaxUnavailable = &maxUnavailable
+	} else {
+		maxUnavailable := intstr.FromInt(1)
+		pdb.Spec.MaxUnavailable = &maxUnavailable
+	}
+	return pdb, nil
+}
+
+// pdbEnabled 判断是否需要生成 pdb
+// 没有填写 disruptionBudget 时, 只有副本数大于1才生成, 否则单副本的应用会无法驱逐
+func pdbEnabled(md *myAppsv1.ZwhDeployment) bool {
+	if md.Spec.DisruptionBudget != nil {
+		return md.Spec.DisruptionBudget.Enable == nil || *md.Spec.DisruptionBudget.Enable
+	}
+	replicas := md.Spec.Replicas
+	if autoscalingEnabled(md) {
+		replicas = *minReplicas(md)
+	}
+	return replicas > 1
+}
+
+// tlsEnabled 判断是否需要为 ingress 开启 https
+func tlsEnabled(md *myAppsv1.ZwhDeployment) bool {
+	return strings.ToLower(md.Spec.Expose.Mode) == myAppsv1.ModeIngress &&
+		md.Spec.Expose.Tls != nil &&
+		md.Spec.Expose.Tls.Enable
+}
+
+// tlsIssuer 获取证书的签发方式,未填写时默认为 selfsigned
+func tlsIssuer(md *myAppsv1.ZwhDeployment) string {
+	if md.Spec.Expose.Tls == nil || md.Spec.Expose.Tls.Issuer == "" {
+		return myAppsv1.TlsIssuerSelfSigned
+	}
+	return strings.ToLower(md.Spec.Expose.Tls.Issuer)
+}
+
+// tlsSecretName 获取证书存放的 secret 名称,未填写时默认与 md 同名
+func tlsSecretName(md *myAppsv1.ZwhDeployment) string {
+	if md.Spec.Expose.Tls == nil || md.Spec.Expose.Tls.SecretName == "" {
+		return md.Name
+	}
+	return md.Spec.Expose.Tls.SecretName
+}
+
 func NewServiceNP(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
-	content, err := parseTemplate(md, "service.yaml")
+	content, err := parseTemplate(md, "service-np.yaml")
 	if err != nil {
 		return nil, err
 	}
@@ -139,5 +2287,8 @@ func NewServiceNP(md *myAppsv1.ZwhDeployment) (*corev1.Service, error) {
 	if err := yaml.Unmarshal(content, svc); err != nil {
 		return nil, err
 	}
+	svc.Spec.Ports = newServicePorts(md, true)
+	setServiceAnnotations(md, svc)
+	setServiceSelector(md, svc)
 	return svc, nil
 }
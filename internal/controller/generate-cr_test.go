@@ -3,15 +3,31 @@ package controller
 import (
 	"fmt"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	networkv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 	myAppsv1 "zwh.com/pkg/zwh-deployment/api/v1"
 )
 
+// TestMain 模板和测试数据都是相对于项目根目录读取的,和 make run 的工作目录保持一致
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
 func readFile(fileName string) []byte {
 	content, err := os.ReadFile(fmt.Sprintf("internal/controller/testdata/%s", fileName))
 	if err != nil {
@@ -56,6 +72,15 @@ func newDeployment(fileName string) *appsv1.Deployment {
 	return md
 }
 
+func newStatefulSet(fileName string) *appsv1.StatefulSet {
+	content := readFile(fileName)
+	sts := new(appsv1.StatefulSet)
+	if err := yaml.Unmarshal(content, sts); err != nil {
+		panic(err)
+	}
+	return sts
+}
+
 func newService(fileName string) *corev1.Service {
 	content := readFile(fileName)
 	md := new(corev1.Service)
@@ -84,6 +109,44 @@ func newIngress(fileName string) *networkv1.Ingress {
 
 }
 
+func newPVC(fileName string) *corev1.PersistentVolumeClaim {
+	content := readFile(fileName)
+	pvc := new(corev1.PersistentVolumeClaim)
+	if err := yaml.Unmarshal(content, pvc); err != nil {
+		panic(err)
+	}
+	// NewPVCs 生成的对象不带 TypeMeta, 由客户端根据类型设置
+	pvc.TypeMeta = metav1.TypeMeta{}
+	return pvc
+}
+
+func newHPA(fileName string) *autoscalingv2.HorizontalPodAutoscaler {
+	content := readFile(fileName)
+	hpa := new(autoscalingv2.HorizontalPodAutoscaler)
+	if err := yaml.Unmarshal(content, hpa); err != nil {
+		panic(err)
+	}
+	return hpa
+}
+
+func newPDB(fileName string) *policyv1.PodDisruptionBudget {
+	content := readFile(fileName)
+	pdb := new(policyv1.PodDisruptionBudget)
+	if err := yaml.Unmarshal(content, pdb); err != nil {
+		panic(err)
+	}
+	return pdb
+}
+
+func newUnstructured(fileName string) *unstructured.Unstructured {
+	content := readFile(fileName)
+	u := new(unstructured.Unstructured)
+	if err := yaml.Unmarshal(content, &u.Object); err != nil {
+		panic(err)
+	}
+	return u
+}
+
 func TestNewDeployment(t *testing.T) {
 	type args struct {
 		md *myAppsv1.ZwhDeployment
@@ -110,6 +173,102 @@ func TestNewDeployment(t *testing.T) {
 			want:    newDeployment("zwh-nodeport-deployment-expect.yaml"),
 			wantErr: false,
 		},
+		{
+			name: "测试使用size预设并覆盖部分resources时候，生成带resources的Deployment资源",
+			args: args{
+				md: newZwhDeployment("zwh-size-cr.yaml"),
+			},
+			want:    newDeployment("zwh-size-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试填写多个命名端口时候，生成带所有端口的Deployment资源",
+			args: args{
+				md: newZwhDeployment("zwh-ports-cr.yaml"),
+			},
+			want:    newDeployment("zwh-ports-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试填写边车和初始化容器时候，和主容器共享存储卷",
+			args: args{
+				md: newZwhDeployment("zwh-sidecar-cr.yaml"),
+			},
+			want:    newDeployment("zwh-sidecar-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试填写config和secretRefs时候，注入环境变量、挂载文件并记录配置哈希",
+			args: args{
+				md: newZwhDeployment("zwh-config-cr.yaml"),
+			},
+			want:    newDeployment("zwh-config-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试填写progressDeadlineSeconds时候，设置到Deployment上",
+			args: args{
+				md: newZwhDeployment("zwh-rollback-cr.yaml"),
+			},
+			want:    newDeployment("zwh-rollback-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试填写调度配置并开启spreadAcrossZones时候，补充可用区的打散约束",
+			args: args{
+				md: newZwhDeployment("zwh-scheduling-cr.yaml"),
+			},
+			want:    newDeployment("zwh-scheduling-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试securityProfile为restricted时候，补全所有容器没有填写的安全配置",
+			args: args{
+				md: newZwhDeployment("zwh-security-cr.yaml"),
+			},
+			want:    newDeployment("zwh-security-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试创建ServiceAccount并授予权限时候，pod使用它并挂载token",
+			args: args{
+				md: newZwhDeployment("zwh-rbac-cr.yaml"),
+			},
+			want:    newDeployment("zwh-rbac-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试填写imagePullSecrets和registryCredentials时候，pod使用所有的拉取凭证",
+			args: args{
+				md: newZwhDeployment("zwh-registry-cr.yaml"),
+			},
+			want:    newDeployment("zwh-registry-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试imagePolicy为pinDigest时候，使用已经解析出的镜像摘要",
+			args: args{
+				md: newZwhDeployment("zwh-pin-cr.yaml"),
+			},
+			want:    newDeployment("zwh-pin-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试自定义健康检查时候，没有填写的端口使用port",
+			args: args{
+				md: newZwhDeployment("zwh-probe-cr.yaml"),
+			},
+			want:    newDeployment("zwh-probe-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试使用volumes和storage时候，生成挂载了存储卷的Deployment资源",
+			args: args{
+				md: newZwhDeployment("zwh-storage-cr.yaml"),
+			},
+			want:    newDeployment("zwh-storage-deployment-expect.yaml"),
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -125,6 +284,119 @@ func TestNewDeployment(t *testing.T) {
 	}
 }
 
+func TestNewStatefulSet(t *testing.T) {
+	type args struct {
+		md *myAppsv1.ZwhDeployment
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *appsv1.StatefulSet
+		wantErr bool
+	}{
+		{
+			name: "测试使用StatefulSet时候，storage生成volumeClaimTemplates",
+			args: args{
+				md: newZwhDeployment("zwh-statefulset-cr.yaml"),
+			},
+			want:    newStatefulSet("zwh-statefulset-expect.yaml"),
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewStatefulSet(tt.args.md)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewStatefulSet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewStatefulSet() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHeadlessService(t *testing.T) {
+	got, err := NewHeadlessService(newZwhDeployment("zwh-statefulset-cr.yaml"))
+	if err != nil {
+		t.Fatalf("NewHeadlessService() error = %v", err)
+	}
+	if want := newService("zwh-statefulset-headless-expect.yaml"); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewHeadlessService() got = %v, want %v", got, want)
+	}
+}
+
+func Test_qosClass(t *testing.T) {
+	guaranteed := func(fileName string) *myAppsv1.ZwhDeployment {
+		md := newZwhDeployment(fileName)
+		md.Spec.Resources = &corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("500m"),
+				corev1.ResourceMemory: resource.MustParse("256Mi"),
+			},
+		}
+		return md
+	}
+	ephemeralStorage := newZwhDeployment("zwh-nodeport-cr.yaml")
+	ephemeralStorage.Spec.Resources = &corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceEphemeralStorage: resource.MustParse("1Gi")},
+	}
+	sidecars := guaranteed("zwh-sidecar-cr.yaml")
+	for _, containers := range [][]corev1.Container{sidecars.Spec.Sidecars, sidecars.Spec.InitContainers} {
+		for i := range containers {
+			containers[i].Resources = *sidecars.Spec.Resources.DeepCopy()
+		}
+	}
+	tests := []struct {
+		name string
+		md   *myAppsv1.ZwhDeployment
+		want corev1.PodQOSClass
+	}{
+		{
+			name: "没有填写resources和size时候，QoS为BestEffort",
+			md:   newZwhDeployment("zwh-nodeport-cr.yaml"),
+			want: corev1.PodQOSBestEffort,
+		},
+		{
+			name: "只填写ephemeral-storage时候，QoS为BestEffort",
+			md:   ephemeralStorage,
+			want: corev1.PodQOSBestEffort,
+		},
+		{
+			name: "requests和limits不相等时候，QoS为Burstable",
+			md:   newZwhDeployment("zwh-size-cr.yaml"),
+			want: corev1.PodQOSBurstable,
+		},
+		{
+			name: "只填写limits时候，QoS为Guaranteed",
+			md:   guaranteed("zwh-nodeport-cr.yaml"),
+			want: corev1.PodQOSGuaranteed,
+		},
+		{
+			name: "边车和初始化容器没有填写resources时候，QoS为Burstable",
+			md:   guaranteed("zwh-sidecar-cr.yaml"),
+			want: corev1.PodQOSBurstable,
+		},
+		{
+			name: "所有容器的requests和limits都相等时候，QoS为Guaranteed",
+			md:   sidecars,
+			want: corev1.PodQOSGuaranteed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := newPodTemplate(tt.md)
+			if err != nil {
+				t.Fatalf("newPodTemplate() error = %v", err)
+			}
+			if got := qosClass(&template.Spec); got != tt.want {
+				t.Errorf("qosClass() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNewIngress(t *testing.T) {
 	type args struct {
 		md *myAppsv1.ZwhDeployment
@@ -144,6 +416,30 @@ func TestNewIngress(t *testing.T) {
 			want:    newIngress("zwh-ingress-ingress-expect.yaml"),
 			wantErr: false,
 		},
+		{
+			name: "测试使用ingress mode 并开启tls时候，生成带tls的ingress资源。",
+			args: args{
+				md: newzwhDeploymentIngress("zwh-ingress-tls-cr.yaml"),
+			},
+			want:    newIngress("zwh-ingress-tls-ingress-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试使用多个域名和路径时候，相同域名的规则合并，需要重写的规则不在稳定版本的 ingress 中。",
+			args: args{
+				md: newzwhDeploymentIngress("zwh-ingress-rules-cr.yaml"),
+			},
+			want:    newIngress("zwh-ingress-rules-ingress-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试规则填写端口名称时候，转发到对应的 service 端口。",
+			args: args{
+				md: newzwhDeploymentIngress("zwh-ports-cr.yaml"),
+			},
+			want:    newIngress("zwh-ports-ingress-expect.yaml"),
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -159,6 +455,283 @@ func TestNewIngress(t *testing.T) {
 	}
 }
 
+func TestNewRewriteIngress(t *testing.T) {
+	tests := []struct {
+		name string
+		md   *myAppsv1.ZwhDeployment
+		want *networkv1.Ingress
+	}{
+		{
+			name: "测试部分规则填写了 rewriteTarget 时候，需要重写的规则生成单独的 ingress。",
+			md:   newzwhDeploymentIngress("zwh-ingress-rules-cr.yaml"),
+			want: newIngress("zwh-ingress-rules-rewrite-ingress-expect.yaml"),
+		},
+		{
+			name: "测试没有规则填写 rewriteTarget 时候，不生成单独的 ingress。",
+			md:   newzwhDeploymentIngress("zwh-ingress-cr.yaml"),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRewriteIngress(tt.md)
+			if err != nil {
+				t.Fatalf("NewRewriteIngress() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewRewriteIngress() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 所有的规则都需要重写时, 全部放在稳定版本的 ingress 中
+	md := newzwhDeploymentIngress("zwh-ingress-rules-cr.yaml")
+	md.Spec.Expose.Rules = md.Spec.Expose.Rules[:1]
+	if got, err := NewRewriteIngress(md); err != nil || got != nil {
+		t.Errorf("NewRewriteIngress() got = %v, err = %v, want nil", got, err)
+	}
+	ig, err := NewIngress(md)
+	if err != nil {
+		t.Fatalf("NewIngress() error = %v", err)
+	}
+	if got := ig.Annotations[rewriteTargetAnnotation]; got != "/$2" {
+		t.Errorf("NewIngress() rewrite target = %s, want /$2", got)
+	}
+}
+
+func TestNewCert(t *testing.T) {
+	type args struct {
+		md *myAppsv1.ZwhDeployment
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *unstructured.Unstructured
+		wantErr bool
+	}{
+		{
+			name: "测试使用ingress mode 并使用clusterissuer签发时候，生成certificate资源。",
+			args: args{
+				md: newzwhDeploymentIngress("zwh-ingress-tls-cr.yaml"),
+			},
+			want:    newUnstructured("zwh-ingress-tls-cert-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试没有开启tls时候，不生成certificate资源。",
+			args: args{
+				md: newzwhDeploymentIngress("zwh-ingress-cr.yaml"),
+			},
+			want:    nil,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCert(tt.args.md)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewCert() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewCert() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPVCs(t *testing.T) {
+	tests := []struct {
+		name string
+		md   *myAppsv1.ZwhDeployment
+		want []*corev1.PersistentVolumeClaim
+	}{
+		{
+			name: "测试使用storage时候，生成pvc资源",
+			md:   newZwhDeployment("zwh-storage-cr.yaml"),
+			want: []*corev1.PersistentVolumeClaim{newPVC("zwh-storage-pvc-expect.yaml")},
+		},
+		{
+			name: "测试没有storage时候，不生成pvc资源",
+			md:   newZwhDeployment("zwh-nodeport-cr.yaml"),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewPVCs(tt.md); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewPVCs() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pvcManaged(t *testing.T) {
+	md := newZwhDeployment("zwh-storage-cr.yaml")
+	md.UID = "md-uid"
+	owned := newPVC("zwh-storage-pvc-expect.yaml")
+	owned.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(md, myAppsv1.GroupVersion.WithKind("ZwhDeployment"))}
+	other := newPVC("zwh-storage-pvc-expect.yaml")
+	other.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(md, myAppsv1.GroupVersion.WithKind("ZwhDeployment"))}
+	other.OwnerReferences[0].UID = "other-uid"
+	user := newPVC("zwh-storage-pvc-expect.yaml")
+	user.Annotations = nil
+	tests := []struct {
+		name string
+		pvc  *corev1.PersistentVolumeClaim
+		want bool
+	}{
+		{
+			name: "pvc由md控制时候，由operator管理",
+			pvc:  owned,
+			want: true,
+		},
+		{
+			name: "reclaimPolicy为retain的pvc有注解时候，由operator管理",
+			pvc:  newPVC("zwh-storage-pvc-expect.yaml"),
+			want: true,
+		},
+		{
+			name: "pvc由其他对象控制时候，不由operator管理",
+			pvc:  other,
+			want: false,
+		},
+		{
+			name: "用户创建的同名pvc，不由operator管理",
+			pvc:  user,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pvcManaged(md, tt.pvc); got != tt.want {
+				t.Errorf("pvcManaged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHTTPRoute(t *testing.T) {
+	got, err := NewHTTPRoute(newZwhDeployment("zwh-gateway-cr.yaml"))
+	if err != nil {
+		t.Fatalf("NewHTTPRoute() error = %v", err)
+	}
+	if want := newUnstructured("zwh-gateway-httproute-expect.yaml"); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewHTTPRoute() got = %v, want %v", got, want)
+	}
+	if _, err := NewHTTPRoute(newZwhDeployment("zwh-ingress-cr.yaml")); err == nil {
+		t.Errorf("NewHTTPRoute() expected an error without spec.expose.gateway")
+	}
+}
+
+func Test_routeCondition(t *testing.T) {
+	route := newUnstructured("zwh-gateway-httproute-status.yaml")
+	tests := []struct {
+		conditionType string
+		wantStatus    string
+		wantReason    string
+		wantFound     bool
+	}{
+		{conditionType: "Accepted", wantStatus: "True", wantReason: "Accepted", wantFound: true},
+		{conditionType: "ResolvedRefs", wantStatus: "False", wantReason: "BackendNotFound", wantFound: true},
+		{conditionType: "Programmed", wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.conditionType, func(t *testing.T) {
+			status, reason, _, found := routeCondition(route, tt.conditionType)
+			if status != tt.wantStatus || reason != tt.wantReason || found != tt.wantFound {
+				t.Errorf("routeCondition() = %s, %s, %v, want %s, %s, %v",
+					status, reason, found, tt.wantStatus, tt.wantReason, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestNewHPA(t *testing.T) {
+	md := newZwhDeployment("zwh-hpa-cr.yaml")
+	got, err := NewHPA(md)
+	if err != nil {
+		t.Fatalf("NewHPA() error = %v", err)
+	}
+	if want := newHPA("zwh-hpa-expect.yaml"); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewHPA() got = %v, want %v", got, want)
+	}
+	// 开启自动扩缩容时, deployment 的副本数使用 minReplicas
+	deploy, err := NewDeployment(md)
+	if err != nil {
+		t.Fatalf("NewDeployment() error = %v", err)
+	}
+	if *deploy.Spec.Replicas != 2 {
+		t.Errorf("NewDeployment() replicas = %d, want 2", *deploy.Spec.Replicas)
+	}
+}
+
+func TestNewPDB(t *testing.T) {
+	tests := []struct {
+		name string
+		md   *myAppsv1.ZwhDeployment
+		want *policyv1.PodDisruptionBudget
+	}{
+		{
+			name: "测试填写minAvailable时候，生成pdb资源",
+			md:   newZwhDeployment("zwh-pdb-cr.yaml"),
+			want: newPDB("zwh-pdb-expect.yaml"),
+		},
+		{
+			name: "测试没有填写disruptionBudget时候，默认maxUnavailable为1",
+			md:   newZwhDeployment("zwh-ingress-cr.yaml"),
+			want: newPDB("zwh-pdb-default-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPDB(tt.md)
+			if err != nil {
+				t.Fatalf("NewPDB() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewPDB() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pdbEnabled(t *testing.T) {
+	disable := false
+	tests := []struct {
+		name   string
+		mutate func(md *myAppsv1.ZwhDeployment)
+		want   bool
+	}{
+		{
+			name:   "多副本默认生成",
+			mutate: func(md *myAppsv1.ZwhDeployment) {},
+			want:   true,
+		},
+		{
+			name:   "单副本默认不生成",
+			mutate: func(md *myAppsv1.ZwhDeployment) { md.Spec.Replicas = 1 },
+			want:   false,
+		},
+		{
+			name: "显式关闭",
+			mutate: func(md *myAppsv1.ZwhDeployment) {
+				md.Spec.DisruptionBudget = &myAppsv1.DisruptionBudget{Enable: &disable}
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := newZwhDeployment("zwh-ingress-cr.yaml")
+			tt.mutate(md)
+			if got := pdbEnabled(md); got != tt.want {
+				t.Errorf("pdbEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNewService(t *testing.T) {
 	type args struct {
 		md *myAppsv1.ZwhDeployment
@@ -178,6 +751,30 @@ func TestNewService(t *testing.T) {
 			want:    newService("zwh-ingress-service-expect.yaml"),
 			wantErr: false,
 		},
+		{
+			name: "测试填写 servicePort 时，service 端口和容器端口不同",
+			args: args{
+				md: newZwhDeployment("zwh-serviceport-cr.yaml"),
+			},
+			want:    newService("zwh-serviceport-service-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试填写多个命名端口时，只生成暴露的端口",
+			args: args{
+				md: newZwhDeployment("zwh-ports-cr.yaml"),
+			},
+			want:    newService("zwh-ports-service-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试蓝绿发布切换流量后，service 选中 preview 的 pod",
+			args: args{
+				md: newZwhDeployment("zwh-bluegreen-cr.yaml"),
+			},
+			want:    newService("zwh-bluegreen-service-expect.yaml"),
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -193,6 +790,645 @@ func TestNewService(t *testing.T) {
 	}
 }
 
+func TestNewServiceLB(t *testing.T) {
+	got, err := NewServiceLB(newZwhDeployment("zwh-loadbalancer-cr.yaml"))
+	if err != nil {
+		t.Fatalf("NewServiceLB() error = %v", err)
+	}
+	if want := newService("zwh-loadbalancer-service-expect.yaml"); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewServiceLB() got = %v, want %v", got, want)
+	}
+}
+
+func TestNewConfigMap(t *testing.T) {
+	content := readFile("zwh-config-configmap-expect.yaml")
+	want := new(corev1.ConfigMap)
+	if err := yaml.Unmarshal(content, want); err != nil {
+		t.Fatal(err)
+	}
+	// NewConfigMap 生成的对象不带 TypeMeta, 由客户端根据类型设置
+	want.TypeMeta = metav1.TypeMeta{}
+	if got := NewConfigMap(newZwhDeployment("zwh-config-cr.yaml")); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewConfigMap() got = %v, want %v", got, want)
+	}
+	if got := NewConfigMap(newZwhDeployment("zwh-ingress-cr.yaml")); got != nil {
+		t.Errorf("NewConfigMap() without config = %v, want nil", got)
+	}
+}
+
+func TestNewRole(t *testing.T) {
+	md := newZwhDeployment("zwh-rbac-cr.yaml")
+	role := new(rbacv1.Role)
+	if err := yaml.Unmarshal(readFile("zwh-rbac-role-expect.yaml"), role); err != nil {
+		t.Fatal(err)
+	}
+	role.TypeMeta = metav1.TypeMeta{}
+	if got := NewRole(md); !reflect.DeepEqual(got, role) {
+		t.Errorf("NewRole() got = %v, want %v", got, role)
+	}
+	binding := new(rbacv1.RoleBinding)
+	if err := yaml.Unmarshal(readFile("zwh-rbac-rolebinding-expect.yaml"), binding); err != nil {
+		t.Fatal(err)
+	}
+	binding.TypeMeta = metav1.TypeMeta{}
+	if got := NewRoleBinding(md); !reflect.DeepEqual(got, binding) {
+		t.Errorf("NewRoleBinding() got = %v, want %v", got, binding)
+	}
+	if sa := NewServiceAccount(md); sa == nil || sa.Name != md.Name || sa.AutomountServiceAccountToken == nil || !*sa.AutomountServiceAccountToken {
+		t.Errorf("NewServiceAccount() got = %v, want automount token", sa)
+	}
+	// 使用已有的 ServiceAccount 时不创建, 权限绑定到它上面
+	md.Spec.ServiceAccount = &myAppsv1.ServiceAccount{Name: "shared"}
+	if sa := NewServiceAccount(md); sa != nil {
+		t.Errorf("NewServiceAccount() got = %v, want nil", sa)
+	}
+	if got := NewRoleBinding(md); got.Subjects[0].Name != "shared" {
+		t.Errorf("NewRoleBinding() subjects = %v, want shared", got.Subjects)
+	}
+	if got := automountToken(md); got != nil {
+		t.Errorf("automountToken() = %v, want nil", *got)
+	}
+	// 没有 ServiceAccount 时 rbac 不生效
+	md.Spec.ServiceAccount = nil
+	if got := NewRole(md); got != nil {
+		t.Errorf("NewRole() got = %v, want nil", got)
+	}
+}
+
+func TestNewNetworkPolicy(t *testing.T) {
+	want := new(networkv1.NetworkPolicy)
+	if err := yaml.Unmarshal(readFile("zwh-networkpolicy-expect.yaml"), want); err != nil {
+		t.Fatal(err)
+	}
+	want.TypeMeta = metav1.TypeMeta{}
+	if got := NewNetworkPolicy(newZwhDeployment("zwh-networkpolicy-cr.yaml")); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewNetworkPolicy() got = %v, want %v", got, want)
+	}
+	if got := NewNetworkPolicy(newZwhDeployment("zwh-ingress-cr.yaml")); got != nil {
+		t.Errorf("NewNetworkPolicy() without networkPolicy = %v, want nil", got)
+	}
+
+	// 没有填写来源时不限制入口流量, 服务暴露的端口仍然可以访问
+	md := newZwhDeployment("zwh-networkpolicy-cr.yaml")
+	md.Spec.NetworkPolicy.Ingress = nil
+	got := NewNetworkPolicy(md)
+	if got == nil || !reflect.DeepEqual(got.Spec.PolicyTypes, []networkv1.PolicyType{networkv1.PolicyTypeEgress}) || got.Spec.Ingress != nil {
+		t.Errorf("NewNetworkPolicy() with only egress = %v, want only the Egress policy type", got)
+	}
+	md.Spec.NetworkPolicy.Egress = nil
+	if got := NewNetworkPolicy(md); got != nil {
+		t.Errorf("NewNetworkPolicy() with empty networkPolicy = %v, want nil", got)
+	}
+}
+
+func TestNewRegistrySecret(t *testing.T) {
+	md := newZwhDeployment("zwh-registry-cr.yaml")
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "harbor-pull", Namespace: "registry", Labels: map[string]string{"team": "platform"}},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	}
+	want := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "zwhdeployment-test-registry",
+			Namespace:   "team-a",
+			Labels:      map[string]string{"app": "zwhdeployment-test"},
+			Annotations: map[string]string{registrySourceAnnotation: "registry/harbor-pull"},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	}
+	got := NewRegistrySecret(md, source)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewRegistrySecret() got = %v, want %v", got, want)
+	}
+	// 复制的数据和来源互不影响
+	source.Data[corev1.DockerConfigJsonKey][0] = '['
+	if got.Data[corev1.DockerConfigJsonKey][0] != '{' {
+		t.Errorf("NewRegistrySecret() shares data with the source secret")
+	}
+	if !registryCredentialsValid(source) || registryCredentialsValid(&corev1.Secret{Type: corev1.SecretTypeOpaque}) {
+		t.Errorf("registryCredentialsValid() only accepts docker config secrets")
+	}
+}
+
+func Test_recordImageDigest(t *testing.T) {
+	md := newZwhDeployment("zwh-pin-cr.yaml")
+	pinned := newDeployment("zwh-pin-deployment-expect.yaml")
+	if got := deployedImage(md, pinned); got != md.Spec.Image {
+		t.Errorf("deployedImage() = %s, want %s", got, md.Spec.Image)
+	}
+	// 修改镜像后保留上一个镜像, 稳定版本仍然使用带摘要的地址
+	oldDigest := md.Status.Images[0].Digest
+	md.Spec.Image = "nginx:1.26"
+	if _, ok := imageDigest(md); ok {
+		t.Errorf("imageDigest() found a digest for an unresolved image")
+	}
+	recordImageDigest(md, "sha256:new", metav1.Now())
+	if len(md.Status.Images) != 2 || md.Status.Images[0].Image != "nginx:1.26" || md.Status.Images[1].Digest != oldDigest {
+		t.Errorf("recordImageDigest() images = %+v", md.Status.Images)
+	}
+	if got := pinnedImage(md, "nginx:1.25"); got != "nginx:1.25@"+oldDigest {
+		t.Errorf("pinnedImage() = %s, want the previous digest", got)
+	}
+	// 不再使用的镜像被删除
+	md.Spec.Image = "nginx:1.27"
+	recordImageDigest(md, "sha256:newer", metav1.Now())
+	if len(md.Status.Images) != 2 || md.Status.Images[1].Image != "nginx:1.26" {
+		t.Errorf("recordImageDigest() images = %+v, want 1.27 and 1.26", md.Status.Images)
+	}
+	// 金丝雀发布中稳定版本使用的镜像需要保留
+	md.Status.Canary = &myAppsv1.CanaryStatus{StableImage: "nginx:1.26", CanaryImage: "nginx:1.27"}
+	md.Spec.Image = "nginx:1.28"
+	recordImageDigest(md, "sha256:newest", metav1.Now())
+	if len(md.Status.Images) != 3 {
+		t.Errorf("recordImageDigest() images = %+v, want 1.28, 1.27 and 1.26", md.Status.Images)
+	}
+}
+
+func Test_configRefKeys(t *testing.T) {
+	md := newZwhDeployment("zwh-config-cr.yaml")
+	md.Spec.SecretRefs = append(md.Spec.SecretRefs, md.Spec.SecretRefs...)
+	got := configRefKeys(md)
+	seen := map[string]bool{}
+	for _, key := range got {
+		if seen[key] {
+			t.Errorf("configRefKeys() got duplicate key %s", key)
+		}
+		seen[key] = true
+	}
+	for _, ref := range referencedConfig(md) {
+		if key := ref.kind + "/" + ref.name; !seen[key] {
+			t.Errorf("configRefKeys() = %v, missing %s", got, key)
+		}
+	}
+	if got := configRefKeys(newZwhDeployment("zwh-nodeport-cr.yaml")); len(got) != 0 {
+		t.Errorf("configRefKeys() = %v, want empty", got)
+	}
+}
+
+func Test_configHash(t *testing.T) {
+	cm := NewConfigMap(newZwhDeployment("zwh-config-cr.yaml"))
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-credentials"},
+		Data:       map[string][]byte{"username": []byte("root"), "password": []byte("secret")},
+	}
+	hash := configHash([]*corev1.ConfigMap{cm}, []*corev1.Secret{secret})
+	if again := configHash([]*corev1.ConfigMap{cm.DeepCopy()}, []*corev1.Secret{secret.DeepCopy()}); again != hash {
+		t.Errorf("configHash() is not stable: %s != %s", again, hash)
+	}
+	secret.Data["password"] = []byte("changed")
+	if changed := configHash([]*corev1.ConfigMap{cm}, []*corev1.Secret{secret}); changed == hash {
+		t.Errorf("configHash() = %s, want a different hash after the secret changed", changed)
+	}
+	// 去掉引用的 secret 时哈希也不同
+	if moved := configHash([]*corev1.ConfigMap{cm}, nil); moved == hash {
+		t.Errorf("configHash() = %s, want a different hash without the secret", moved)
+	}
+}
+
+func Test_containerStatuses(t *testing.T) {
+	md := newZwhDeployment("zwh-sidecar-cr.yaml")
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}
+	}
+	pods := []corev1.Pod{
+		{Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: "migrate", RestartCount: 3, State: waiting("CrashLoopBackOff")}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "zwhdeployment-test", State: waiting("PodInitializing")},
+				{Name: "fluent-bit", State: waiting("PodInitializing")},
+			},
+		}},
+		{Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: "migrate"}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "zwhdeployment-test", RestartCount: 1},
+				{Name: "fluent-bit", RestartCount: 2, State: waiting("ImagePullBackOff")},
+			},
+		}},
+	}
+	want := []myAppsv1.ContainerStatus{
+		{Name: "migrate", RestartCount: 3, WaitingReason: "CrashLoopBackOff"},
+		{Name: "zwhdeployment-test", RestartCount: 1, WaitingReason: "PodInitializing"},
+		{Name: "fluent-bit", RestartCount: 2, WaitingReason: "PodInitializing"},
+	}
+	if got := containerStatuses(md, pods); !reflect.DeepEqual(got, want) {
+		t.Errorf("containerStatuses() = %+v, want %+v", got, want)
+	}
+	want = []myAppsv1.ContainerStatus{{Name: "migrate"}, {Name: "zwhdeployment-test"}, {Name: "fluent-bit"}}
+	if got := containerStatuses(md, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("containerStatuses() without pods = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewCanaryDeployment(t *testing.T) {
+	want := newDeployment("zwh-canary-deployment-expect.yaml")
+	got, err := NewCanaryDeployment(newZwhDeployment("zwh-canary-cr.yaml"))
+	if err != nil {
+		t.Fatalf("NewCanaryDeployment() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewCanaryDeployment() got = %v, want %v", got, want)
+	}
+}
+
+func TestNewCanaryIngress(t *testing.T) {
+	md := newZwhDeployment("zwh-canary-cr.yaml")
+	want := newIngress("zwh-canary-ingress-expect.yaml")
+	got, err := NewCanaryIngress(md, canaryWeight(md))
+	if err != nil {
+		t.Fatalf("NewCanaryIngress() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewCanaryIngress() got = %v, want %v", got, want)
+	}
+}
+
+func Test_stableZwhDeployment(t *testing.T) {
+	md := newZwhDeployment("zwh-canary-cr.yaml")
+	if got := stableZwhDeployment(md).Spec.Image; got != "nginx:1.24" {
+		t.Errorf("stableZwhDeployment() image = %s, want nginx:1.24 before promotion", got)
+	}
+	md.Status.Canary.Phase = myAppsv1.CanaryPhasePromoting
+	if got := stableZwhDeployment(md).Spec.Image; got != "nginx:1.25" {
+		t.Errorf("stableZwhDeployment() image = %s, want nginx:1.25 after promotion", got)
+	}
+
+	// 蓝绿发布切换流量后, 稳定版本等到 scaleDownDelay 结束才更新镜像
+	md = newZwhDeployment("zwh-bluegreen-cr.yaml")
+	md.Spec.Image = "nginx:1.26"
+	for phase, want := range map[string]string{
+		myAppsv1.BlueGreenPhaseSwitched:  "nginx:1.24",
+		myAppsv1.BlueGreenPhasePromoting: "nginx:1.25",
+		myAppsv1.BlueGreenPhasePromoted:  "nginx:1.26",
+	} {
+		md.Status.BlueGreen.Phase = phase
+		if got := stableZwhDeployment(md).Spec.Image; got != want {
+			t.Errorf("stableZwhDeployment() image = %s in phase %s, want %s", got, phase, want)
+		}
+	}
+}
+
+func TestNewPreviewDeployment(t *testing.T) {
+	md := newZwhDeployment("zwh-bluegreen-cr.yaml")
+	// 切换流量之后 spec 中的镜像再次变化, preview 保持已经验证的镜像
+	md.Spec.Image = "nginx:1.26"
+	want := newDeployment("zwh-bluegreen-deployment-expect.yaml")
+	got, err := NewPreviewDeployment(md)
+	if err != nil {
+		t.Fatalf("NewPreviewDeployment() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewPreviewDeployment() got = %v, want %v", got, want)
+	}
+}
+
+func TestNewPreviewIngress(t *testing.T) {
+	want := newIngress("zwh-bluegreen-ingress-expect.yaml")
+	got, err := NewPreviewIngress(newZwhDeployment("zwh-bluegreen-cr.yaml"))
+	if err != nil {
+		t.Fatalf("NewPreviewIngress() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewPreviewIngress() got = %v, want %v", got, want)
+	}
+}
+
+func Test_nextBlueGreenAction(t *testing.T) {
+	now := time.Now()
+	ready := func() *appsv1.Deployment {
+		deploy := newDeployment("zwh-bluegreen-deployment-expect.yaml")
+		deploy.Status.UpdatedReplicas = 2
+		deploy.Status.AvailableReplicas = 2
+		return deploy
+	}
+	notReady := ready()
+	notReady.Status.AvailableReplicas = 1
+	failed := ready()
+	failed.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:    appsv1.DeploymentProgressing,
+		Status:  corev1.ConditionFalse,
+		Message: "ReplicaSet has timed out progressing",
+	}}
+	tests := []struct {
+		name        string
+		phase       string
+		switched    time.Duration
+		autoPromote bool
+		preview     *appsv1.Deployment
+		promote     bool
+		abort       bool
+		want        blueGreenAction
+		wantMessage bool
+	}{
+		{name: "preview 没有就绪时等待", phase: myAppsv1.BlueGreenPhasePreviewing, preview: notReady, want: blueGreenWait},
+		{name: "preview 就绪后等待注解", phase: myAppsv1.BlueGreenPhasePreviewing, preview: ready(), want: blueGreenPause},
+		{name: "添加 promote 注解后切换流量", phase: myAppsv1.BlueGreenPhasePaused, preview: ready(), promote: true, want: blueGreenSwitch},
+		{name: "autoPromote 时就绪后直接切换流量", phase: myAppsv1.BlueGreenPhasePreviewing, autoPromote: true, preview: ready(), want: blueGreenSwitch},
+		{name: "scaleDownDelay 没有结束时等待", phase: myAppsv1.BlueGreenPhaseSwitched, switched: time.Minute, preview: ready(), want: blueGreenWait},
+		{name: "scaleDownDelay 结束后推广", phase: myAppsv1.BlueGreenPhaseSwitched, switched: 6 * time.Minute, preview: ready(), want: blueGreenPromote},
+		{name: "添加 promote 注解时跳过 scaleDownDelay", phase: myAppsv1.BlueGreenPhaseSwitched, preview: ready(), promote: true, want: blueGreenPromote},
+		{name: "切换流量后添加 abort 注解时中止", phase: myAppsv1.BlueGreenPhaseSwitched, preview: ready(), abort: true, want: blueGreenAbort, wantMessage: true},
+		{name: "preview 超时没有就绪时中止", phase: myAppsv1.BlueGreenPhasePreviewing, preview: failed, want: blueGreenAbort, wantMessage: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := newZwhDeployment("zwh-bluegreen-cr.yaml")
+			md.Spec.Strategy.BlueGreen.AutoPromote = tt.autoPromote
+			md.Status.BlueGreen.Phase = tt.phase
+			md.Status.BlueGreen.SwitchTime = &metav1.Time{Time: now.Add(-tt.switched)}
+			got, message := nextBlueGreenAction(md, tt.preview, tt.promote, tt.abort, now)
+			if got != tt.want {
+				t.Errorf("nextBlueGreenAction() = %v, want %v", got, tt.want)
+			}
+			if (message != "") != tt.wantMessage {
+				t.Errorf("nextBlueGreenAction() message = %q, wantMessage %v", message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func Test_nextCanaryAction(t *testing.T) {
+	now := time.Now()
+	ready := func() *appsv1.Deployment {
+		deploy := newDeployment("zwh-canary-deployment-expect.yaml")
+		deploy.Status.UpdatedReplicas = 1
+		deploy.Status.AvailableReplicas = 1
+		return deploy
+	}
+	notReady := ready()
+	notReady.Status.AvailableReplicas = 0
+	failed := ready()
+	failed.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:    appsv1.DeploymentProgressing,
+		Status:  corev1.ConditionFalse,
+		Message: "ReplicaSet has timed out progressing",
+	}}
+	tests := []struct {
+		name        string
+		step        int32
+		started     time.Duration
+		canary      *appsv1.Deployment
+		promote     bool
+		abort       bool
+		want        canaryAction
+		wantMessage bool
+	}{
+		{name: "canary 没有就绪时等待", step: 0, canary: notReady, want: canaryWait},
+		{name: "暂停时间没有结束时等待", step: 0, started: time.Minute, canary: ready(), want: canaryWait},
+		{name: "暂停时间结束后进入下一步", step: 0, started: 11 * time.Minute, canary: ready(), want: canaryAdvance},
+		{name: "人工暂停的步骤等待注解", step: 1, canary: ready(), want: canaryPause},
+		{name: "人工暂停的步骤添加 promote 注解后进入下一步", step: 1, canary: ready(), promote: true, want: canaryAdvance},
+		{name: "最后一步完成后推广", step: 2, canary: ready(), want: canaryPromote},
+		{name: "添加 abort 注解时中止", step: 1, canary: ready(), abort: true, want: canaryAbort, wantMessage: true},
+		{name: "canary 超时没有就绪时中止", step: 0, canary: failed, want: canaryAbort, wantMessage: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := newZwhDeployment("zwh-canary-cr.yaml")
+			md.Status.Canary.CurrentStep = tt.step
+			md.Status.Canary.StepStartTime = &metav1.Time{Time: now.Add(-tt.started)}
+			got, message := nextCanaryAction(md, tt.canary, tt.promote, tt.abort, now)
+			if got != tt.want {
+				t.Errorf("nextCanaryAction() = %v, want %v", got, tt.want)
+			}
+			if (message != "") != tt.wantMessage {
+				t.Errorf("nextCanaryAction() message = %q, wantMessage %v", message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func Test_updateRollout(t *testing.T) {
+	md := newZwhDeployment("zwh-rollback-cr.yaml")
+	md.Generation = 1
+	deploy := newDeployment("zwh-rollback-deployment-expect.yaml")
+	deploy.Generation = 1
+	deploy.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+
+	// 1. 滚动更新完成时记录成功发布的 pod 模板
+	if updateRollout(md, deploy) {
+		t.Fatalf("updateRollout() = true, want false after the rollout is complete")
+	}
+	rollout := md.Status.Rollout
+	if rollout.Phase != myAppsv1.RolloutPhaseComplete || rollout.LastGoodImage != "nginx:1.25" ||
+		!reflect.DeepEqual(rollout.LastGoodTemplate, &deploy.Spec.Template) {
+		t.Errorf("updateRollout() rollout = %+v, want the template of nginx:1.25 recorded", rollout)
+	}
+
+	// 2. 新镜像的 deployment controller 还没有处理时, 不使用上一次的 Progressing condition
+	md.Generation = 2
+	failed := deploy.DeepCopy()
+	failed.Generation = 2
+	failed.Spec.Template.Spec.Containers[0].Image = "nginx:broken"
+	failed.Status.UpdatedReplicas = 1
+	failed.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:    appsv1.DeploymentProgressing,
+		Status:  corev1.ConditionFalse,
+		Reason:  "ProgressDeadlineExceeded",
+		Message: `ReplicaSet "zwhdeployment-test-5d4b" has timed out progressing.`,
+	}}
+	if updateRollout(md, failed) || md.Status.Rollout.Phase != myAppsv1.RolloutPhaseProgressing {
+		t.Errorf("updateRollout() phase = %s, want Progressing before the deployment is observed", md.Status.Rollout.Phase)
+	}
+
+	// 3. 超过 progressDeadlineSeconds 时发布失败, 只报告一次
+	failed.Status.ObservedGeneration = 2
+	if !updateRollout(md, failed) {
+		t.Fatalf("updateRollout() = false, want true when the progress deadline is exceeded")
+	}
+	if rollout.Phase != myAppsv1.RolloutPhaseFailed || rollout.FailedImage != "nginx:broken" || rollout.FailedGeneration != 2 {
+		t.Errorf("updateRollout() rollout = %+v, want nginx:broken failed at generation 2", rollout)
+	}
+	if updateRollout(md, failed) {
+		t.Errorf("updateRollout() = true, want the failure reported only once")
+	}
+	if got := rollbackTemplate(md); got != nil {
+		t.Errorf("rollbackTemplate() = %v, want nil before rolling back", got)
+	}
+
+	// 4. 回滚后使用上一次成功发布的 pod 模板, 修改 spec 后重新发布
+	rollout.Phase = myAppsv1.RolloutPhaseRolledBack
+	if got := rollbackTemplate(md); !reflect.DeepEqual(got, &deploy.Spec.Template) {
+		t.Errorf("rollbackTemplate() = %v, want the template of nginx:1.25", got)
+	}
+	md.Generation = 3
+	if got := rollbackTemplate(md); got != nil {
+		t.Errorf("rollbackTemplate() = %v, want nil after the spec changed", got)
+	}
+}
+
+func Test_waitRequeue(t *testing.T) {
+	conditions := []myAppsv1.Condition{
+		{Type: myAppsv1.ConditionTypeDeployment, Status: myAppsv1.ConditionStatusFalse, Reason: myAppsv1.ConditionReasonRolledBack},
+		{Type: myAppsv1.ConditionTypeService, Status: myAppsv1.ConditionStatusTrue, Reason: myAppsv1.ConditionReasonServiceReady},
+	}
+	if waitRequeue(conditions) {
+		t.Errorf("waitRequeue() = true, want false when only the rollout failed")
+	}
+	conditions[1].Status, conditions[1].Reason = myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonServiceNotReady
+	if !waitRequeue(conditions) {
+		t.Errorf("waitRequeue() = false, want true when the service is not ready")
+	}
+}
+
+func Test_securityViolations(t *testing.T) {
+	privileged, root := true, int64(0)
+	tests := []struct {
+		name    string
+		profile string
+		mutate  func(md *myAppsv1.ZwhDeployment)
+		want    []string
+	}{
+		{
+			name:    "restricted 补全后满足要求",
+			profile: myAppsv1.SecurityProfileRestricted,
+		},
+		{
+			name:    "privileged 不做检查",
+			profile: myAppsv1.SecurityProfilePrivileged,
+			mutate: func(md *myAppsv1.ZwhDeployment) {
+				md.Spec.SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+			},
+		},
+		{
+			name:    "baseline 不允许特权容器、hostPath 和额外的 capabilities",
+			profile: myAppsv1.SecurityProfileBaseline,
+			mutate: func(md *myAppsv1.ZwhDeployment) {
+				md.Spec.SecurityContext = &corev1.SecurityContext{Privileged: &privileged,
+					Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN", "CHOWN"}}}
+				md.Spec.Volumes = []corev1.Volume{{Name: "docker", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run/docker.sock"}}}}
+			},
+			want: []string{
+				`volume "docker" must not use hostPath`,
+				`container "zwhdeployment-test" must not set privileged=true`,
+				`container "zwhdeployment-test" must not add capability NET_ADMIN`,
+			},
+		},
+		{
+			name:    "restricted 不允许以 root 运行和添加 capabilities",
+			profile: myAppsv1.SecurityProfileRestricted,
+			mutate: func(md *myAppsv1.ZwhDeployment) {
+				md.Spec.PodSecurityContext = &corev1.PodSecurityContext{RunAsUser: &root}
+				md.Spec.SecurityContext = &corev1.SecurityContext{Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"CHOWN"}}}
+			},
+			want: []string{
+				"pod must not set runAsUser=0",
+				`container "zwhdeployment-test" must only add capability NET_BIND_SERVICE`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := newZwhDeployment("zwh-security-cr.yaml")
+			md.Spec.SecurityProfile = tt.profile
+			if tt.mutate != nil {
+				tt.mutate(md)
+			}
+			template, err := newPodTemplate(md)
+			if err != nil {
+				t.Fatalf("newPodTemplate() error = %v", err)
+			}
+			if got := securityViolations(tt.profile, &template.Spec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("securityViolations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewControllerRevision(t *testing.T) {
+	md := newZwhDeployment("zwh-sidecar-cr.yaml")
+	template, err := newPodTemplate(md)
+	if err != nil {
+		t.Fatalf("newPodTemplate() error = %v", err)
+	}
+	rev, err := NewControllerRevision(md, template, 3)
+	if err != nil {
+		t.Fatalf("NewControllerRevision() error = %v", err)
+	}
+	if rev.Revision != 3 || rev.Labels[revisionLabel] != md.Name {
+		t.Errorf("NewControllerRevision() revision = %d, labels = %v", rev.Revision, rev.Labels)
+	}
+	data, err := readRevision(rev)
+	if err != nil {
+		t.Fatalf("readRevision() error = %v", err)
+	}
+	if want := podImages(&template.Spec); !reflect.DeepEqual(data.Images, want) || len(want) != 3 || want[1] != md.Spec.Image {
+		t.Errorf("NewControllerRevision() images = %v, want %v", data.Images, want)
+	}
+	if !reflect.DeepEqual(data.Spec, md.Spec) || data.imageDigest() != "" {
+		t.Errorf("readRevision() = %+v, digest %s, want %+v without a digest", data.Spec, data.imageDigest(), md.Spec)
+	}
+	// 相同的 spec 生成相同的名称, spec 变化时名称也变化
+	same, _ := NewControllerRevision(md.DeepCopy(), template, 4)
+	if same.Name != rev.Name {
+		t.Errorf("NewControllerRevision() name = %s, want %s for the same spec", same.Name, rev.Name)
+	}
+	md.Spec.Image = "nginx:1.26"
+	template, _ = newPodTemplate(md)
+	changed, _ := NewControllerRevision(md, template, 4)
+	if changed.Name == rev.Name {
+		t.Errorf("NewControllerRevision() name = %s, want a different name after the image changed", changed.Name)
+	}
+
+	// imagePolicy 为 pinDigest 时记录带摘要的镜像, tag 指向的内容变化时生成新的版本
+	digest := "sha256:" + strings.Repeat("a", 64)
+	md.Spec.ImagePolicy = myAppsv1.ImagePolicyPinDigest
+	md.Status.Images = []myAppsv1.ImageDigest{{Image: md.Spec.Image, Digest: digest}}
+	template, _ = newPodTemplate(md)
+	pinned, err := NewControllerRevision(md, template, 5)
+	if err != nil {
+		t.Fatalf("NewControllerRevision() error = %v", err)
+	}
+	data, _ = readRevision(pinned)
+	if data.Images[1] != md.Spec.Image+"@"+digest || data.imageDigest() != digest {
+		t.Errorf("NewControllerRevision() images = %v, digest = %s, want %s pinned to %s", data.Images, data.imageDigest(), md.Spec.Image, digest)
+	}
+	md.Status.Images[0].Digest = "sha256:" + strings.Repeat("b", 64)
+	template, _ = newPodTemplate(md)
+	if repinned, _ := NewControllerRevision(md, template, 6); repinned.Name == pinned.Name {
+		t.Errorf("NewControllerRevision() name = %s, want a different name after the digest changed", repinned.Name)
+	}
+}
+
+func Test_revisionsToPrune(t *testing.T) {
+	revision := func(name string, number int64) appsv1.ControllerRevision {
+		return appsv1.ControllerRevision{ObjectMeta: metav1.ObjectMeta{Name: name}, Revision: number}
+	}
+	revisions := []appsv1.ControllerRevision{
+		revision("md-c", 3), revision("md-a", 1), revision("md-d", 4), revision("md-b", 2),
+	}
+	names := func(revisions []appsv1.ControllerRevision) []string {
+		var names []string
+		for _, rev := range revisions {
+			names = append(names, rev.Name)
+		}
+		return names
+	}
+	if got := names(revisionsToPrune(revisions, 4, "md-d")); got != nil {
+		t.Errorf("revisionsToPrune() = %v, want nothing within the limit", got)
+	}
+	if got, want := names(revisionsToPrune(revisions, 2, "md-d")), []string{"md-a", "md-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("revisionsToPrune() = %v, want %v", got, want)
+	}
+	// 回滚到旧版本时当前版本的版本号最大, 不会被删除
+	if got, want := names(revisionsToPrune(revisions, 1, "md-a")), []string{"md-b", "md-c", "md-d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("revisionsToPrune() = %v, want %v", got, want)
+	}
+}
+
+func Test_lbAddress(t *testing.T) {
+	svc := newService("zwh-loadbalancer-service-expect.yaml")
+	if got := lbAddress(svc); got != "" {
+		t.Errorf("lbAddress() = %q, want empty before the address is assigned", got)
+	}
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}}
+	if got := lbAddress(svc); got != "lb.example.com" {
+		t.Errorf("lbAddress() = %q, want lb.example.com", got)
+	}
+}
+
 func TestNewServiceNP(t *testing.T) {
 	type args struct {
 		md *myAppsv1.ZwhDeployment
@@ -212,6 +1448,14 @@ func TestNewServiceNP(t *testing.T) {
 			want:    newService("zwh-nodeport-service-expect.yaml"),
 			wantErr: false,
 		},
+		{
+			name: "测试填写多个命名端口时，每个端口使用自己的节点端口",
+			args: args{
+				md: newZwhDeployment("zwh-ports-cr.yaml"),
+			},
+			want:    newServiceNP("zwh-ports-service-np-expect.yaml"),
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -221,8 +1465,7 @@ func TestNewServiceNP(t *testing.T) {
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				//t.Errorf("NewServiceNP() got = %v, want %v", got, tt.want),这句不知道啥问题，不注释掉就有报错
-				fmt.Println("hh")
+				t.Errorf("NewServiceNP() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
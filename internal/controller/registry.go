@@ -0,0 +1,333 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RegistryClient 通过 registry v2 API 解析镜像的摘要, 支持匿名、basic 和 bearer token 认证
+type RegistryClient struct {
+	//HTTPClient 访问镜像仓库使用的客户端, 为空时使用 30 秒超时的默认客户端
+	HTTPClient *http.Client
+}
+
+// registryAuth 镜像仓库的用户名和密码, 来自 pod 的 imagePullSecrets
+type registryAuth struct {
+	Username string
+	Password string
+}
+
+// manifestMediaTypes 解析摘要时接受的 manifest 类型, 多架构镜像使用 index 的摘要
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// digestRegexp 摘要的格式 algorithm:hex
+var digestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+
+// dockerHub docker hub 的镜像地址和 registry API 的地址不同
+const (
+	dockerHubRegistry = "docker.io"
+	dockerHubAPI      = "registry-1.docker.io"
+)
+
+// imageReference 拆分后的镜像地址 [registry/]repository[:tag][@digest]
+type imageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageReference 拆分镜像地址, 没有 registry 时为 docker hub, 没有 tag 时为 latest
+func parseImageReference(image string) imageReference {
+	ref := imageReference{Registry: dockerHubRegistry}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+	}
+	// 第一段包含 . 或 : 或者为 localhost 时是 registry 地址
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry, name = first, name[i+1:]
+		}
+	}
+	// tag 在最后一段中, registry 的端口已经去掉
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	if ref.Registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Repository = name
+	return ref
+}
+
+// pinImage 在镜像地址后面加上摘要, 保留 tag 方便查看
+func pinImage(image, digest string) string {
+	if strings.Contains(image, "@") {
+		return image
+	}
+	return image + "@" + digest
+}
+
+// Resolve 获取镜像 tag 对应的摘要, 镜像地址中已经带有摘要时直接返回
+func (c *RegistryClient) Resolve(ctx context.Context, image string, auths map[string]registryAuth) (string, error) {
+	ref := parseImageReference(image)
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	host := ref.Registry
+	if host == dockerHubRegistry {
+		host = dockerHubAPI
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, ref.Repository, ref.Tag)
+	auth, hasAuth := auths[ref.Registry]
+
+	// 1. 先匿名请求, 需要认证时按照 WWW-Authenticate 获取 token 后重试
+	authorization := ""
+	resp, err := c.manifest(ctx, http.MethodHead, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err = c.authorize(ctx, resp.Header.Get("WWW-Authenticate"), ref, auth, hasAuth)
+		if err != nil {
+			return "", err
+		}
+		if resp, err = c.manifest(ctx, http.MethodHead, manifestURL, authorization); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get manifest of %s: %s", image, resp.Status)
+	}
+	// 2. 有些镜像仓库的 HEAD 请求不返回摘要, 使用 GET 获取 manifest 计算摘要
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return c.manifestDigest(ctx, manifestURL, authorization)
+	}
+	if !digestRegexp.MatchString(digest) {
+		return "", fmt.Errorf("registry returned an invalid digest %q for %s", digest, image)
+	}
+	return digest, nil
+}
+
+// manifest 请求 manifest, 只读取响应头, 响应体在返回前关闭
+func (c *RegistryClient) manifest(ctx context.Context, method, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return resp, nil
+}
+
+// manifestDigest 使用 GET 获取 manifest, 按照内容计算 sha256 摘要
+func (c *RegistryClient) manifestDigest(ctx context.Context, manifestURL, authorization string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get manifest %s: %s", manifestURL, resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digestRegexp.MatchString(digest) {
+		return digest, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// authorize 按照 WWW-Authenticate 生成 Authorization 请求头
+// Basic 直接使用用户名和密码, Bearer 先从 realm 获取 pull 权限的 token
+func (c *RegistryClient) authorize(ctx context.Context, challenge string, ref imageReference, auth registryAuth, hasAuth bool) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if !hasAuth {
+			return "", fmt.Errorf("registry %s requires credentials, add them to imagePullSecrets", ref.Registry)
+		}
+		return basicAuthorization(auth), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("registry %s returned an unsupported challenge %q", ref.Registry, challenge)
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("registry %s returned an invalid token realm %q", ref.Registry, params["realm"])
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", ref.Repository))
+	tokenURL.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasAuth {
+		req.Header.Set("Authorization", basicAuthorization(auth))
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get token from %s: %s", tokenURL.Host, resp.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decode token from %s: %w", tokenURL.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("registry %s returned an empty token", ref.Registry)
+	}
+	return "Bearer " + token.Token, nil
+}
+
+func (c *RegistryClient) client() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// parseChallenge 解析 WWW-Authenticate, 例如 Bearer realm="https://auth.example.com/token",service="registry"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var pair string
+		// 参数的值用引号包围, 值中可能包含逗号
+		key, value, ok := strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			pair, rest = value[1:end+1], value[end+2:]
+		} else {
+			pair, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = pair
+	}
+	return strings.ToLower(scheme), params
+}
+
+func basicAuthorization(auth registryAuth) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password))
+}
+
+// registryAuths 从 docker 配置类型的 secret 中读取每个镜像仓库的用户名和密码
+// 前面的 secret 优先, 和 kubelet 使用 imagePullSecrets 的顺序一致
+func registryAuths(secrets []*corev1.Secret) map[string]registryAuth {
+	auths := map[string]registryAuth{}
+	for _, secret := range secrets {
+		config := struct {
+			Auths map[string]dockerAuth `json:"auths"`
+		}{}
+		switch secret.Type {
+		case corev1.SecretTypeDockerConfigJson:
+			if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+				continue
+			}
+		case corev1.SecretTypeDockercfg:
+			if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &config.Auths); err != nil {
+				continue
+			}
+		default:
+			continue
+		}
+		for server, entry := range config.Auths {
+			registry := registryHost(server)
+			if _, ok := auths[registry]; ok {
+				continue
+			}
+			if auth, ok := entry.decode(); ok {
+				auths[registry] = auth
+			}
+		}
+	}
+	return auths
+}
+
+// dockerAuth docker 配置中一个镜像仓库的认证信息, auth 为 base64 编码的 username:password
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+func (a dockerAuth) decode() (registryAuth, bool) {
+	if a.Username != "" {
+		return registryAuth{Username: a.Username, Password: a.Password}, true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return registryAuth{}, false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	return registryAuth{Username: username, Password: password}, ok
+}
+
+// registryHost docker 配置中的地址可能带有协议和路径, 例如 https://index.docker.io/v1/
+func registryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if host == "index.docker.io" || host == dockerHubAPI {
+		return dockerHubRegistry
+	}
+	return host
+}
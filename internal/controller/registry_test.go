@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakeRegistry 进程内的 OCI 镜像仓库, 使用 bearer token 认证, 只支持查询 manifest
+type fakeRegistry struct {
+	*httptest.Server
+	// manifests 仓库中的 manifest, key 为 repository:tag
+	manifests map[string]string
+	// noDigestHeader 为 true 时不返回 Docker-Content-Digest, 模拟不返回摘要的镜像仓库
+	noDigestHeader bool
+}
+
+const (
+	fakeRegistryUser     = "robot"
+	fakeRegistryPassword = "secret"
+	fakeRegistryToken    = "pull-token"
+)
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	registry := &fakeRegistry{manifests: map[string]string{
+		"team/app:v1": `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`,
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != fakeRegistryUser || password != fakeRegistryPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("service") != "fake-registry" || !strings.HasSuffix(r.URL.Query().Get("scope"), ":pull") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, fakeRegistryToken)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeRegistryToken {
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry",scope="repository:x:pull"`, registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v2/")
+		repository, tag, ok := strings.Cut(path, "/manifests/")
+		manifest, found := registry.manifests[repository+":"+tag]
+		if !ok || !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !registry.noDigestHeader {
+			w.Header().Set("Docker-Content-Digest", sha256Digest(manifest))
+		}
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, manifest)
+		}
+	})
+	registry.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(registry.Close)
+	return registry
+}
+
+// host 镜像地址中使用的 registry, 例如 127.0.0.1:12345
+func (f *fakeRegistry) host() string {
+	return strings.TrimPrefix(f.URL, "https://")
+}
+
+func sha256Digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestRegistryClient_Resolve(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := &RegistryClient{HTTPClient: registry.Client()}
+	auths := map[string]registryAuth{registry.host(): {Username: fakeRegistryUser, Password: fakeRegistryPassword}}
+	manifest := registry.manifests["team/app:v1"]
+
+	tests := []struct {
+		name           string
+		image          string
+		auths          map[string]registryAuth
+		noDigestHeader bool
+		want           string
+		wantErr        string
+	}{
+		{
+			name:  "使用 bearer token 解析 tag",
+			image: registry.host() + "/team/app:v1",
+			auths: auths,
+			want:  sha256Digest(manifest),
+		},
+		{
+			name:           "没有返回摘要时按照 manifest 内容计算",
+			image:          registry.host() + "/team/app:v1",
+			auths:          auths,
+			noDigestHeader: true,
+			want:           sha256Digest(manifest),
+		},
+		{
+			name:  "已经带有摘要时不访问镜像仓库",
+			image: "nginx:1.25@" + sha256Digest("pinned"),
+			want:  sha256Digest("pinned"),
+		},
+		{
+			name:    "没有凭证时无法获取 token",
+			image:   registry.host() + "/team/app:v1",
+			wantErr: "401",
+		},
+		{
+			name:    "tag 不存在",
+			image:   registry.host() + "/team/app:v2",
+			auths:   auths,
+			wantErr: "404",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry.noDigestHeader = tt.noDigestHeader
+			got, err := client.Resolve(context.Background(), tt.image, tt.auths)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Resolve() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseImageReference(t *testing.T) {
+	digest := sha256Digest("image")
+	tests := []struct {
+		image string
+		want  imageReference
+	}{
+		{image: "nginx", want: imageReference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"}},
+		{image: "bitnami/redis:7.2", want: imageReference{Registry: "docker.io", Repository: "bitnami/redis", Tag: "7.2"}},
+		{image: "localhost/app", want: imageReference{Registry: "localhost", Repository: "app", Tag: "latest"}},
+		{image: "registry.example.com:5000/team/app:v1", want: imageReference{Registry: "registry.example.com:5000", Repository: "team/app", Tag: "v1"}},
+		{image: "ghcr.io/org/app:v1@" + digest, want: imageReference{Registry: "ghcr.io", Repository: "org/app", Tag: "v1", Digest: digest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := parseImageReference(tt.image); got != tt.want {
+				t.Errorf("parseImageReference() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_registryAuths(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:from-auth"))
+	secrets := []*corev1.Secret{
+		{
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(
+				`{"auths":{"https://index.docker.io/v1/":{"username":"hub","password":"p1"},"harbor.example.com":{"auth":"` + auth + `"}}}`)},
+		},
+		{
+			// 后面的 secret 中相同的镜像仓库不覆盖前面的
+			Type: corev1.SecretTypeDockercfg,
+			Data: map[string][]byte{corev1.DockerConfigKey: []byte(`{"harbor.example.com":{"username":"other","password":"p2"}}`)},
+		},
+		{Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"password": []byte("ignored")}},
+	}
+	got := registryAuths(secrets)
+	want := map[string]registryAuth{
+		"docker.io":          {Username: "hub", Password: "p1"},
+		"harbor.example.com": {Username: "robot", Password: "from-auth"},
+	}
+	if len(got) != len(want) || got["docker.io"] != want["docker.io"] || got["harbor.example.com"] != want["harbor.example.com"] {
+		t.Errorf("registryAuths() = %v, want %v", got, want)
+	}
+}
@@ -18,20 +18,34 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	networkv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
 	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,11 +54,23 @@ import (
 
 var WaitRequeue = 10 * time.Second
 
+// configRefIndex md 引用的已有 configmap 和 secret 的字段索引, 值由 configRefKeys 生成
+const configRefIndex = "spec.configRefs"
+
+// registryCredentialsIndex md 引用的镜像仓库凭证的字段索引, 值为 secret 名称
+const registryCredentialsIndex = "spec.registryCredentials.name"
+
 // ZwhDeploymentReconciler reconciles a ZwhDeployment object
 type ZwhDeploymentReconciler struct {
 	client.Client
-	DynamicClient dynamic.Interface // 用来访问 issuer和certificate资源
+	DynamicClient dynamic.Interface // 用来访问 issuer、certificate和httproute资源
+	APIReader     client.Reader     // 不走缓存直接读取 pod、event、configmap 和 secret, 避免启动整个集群的 informer
 	Scheme        *runtime.Scheme
+	Recorder      record.EventRecorder // 发布失败和回滚时记录事件
+	Registry      *RegistryClient      // imagePolicy 为 pinDigest 时解析镜像摘要
+	// 集中管理镜像仓库凭证的 namespace, registryCredentials 只能引用这里的 secret, 为空时不复制
+	RegistryNamespace string
+	registryCache     cache.Cache // 只缓存 RegistryNamespace 中的 secret, 由 SetupWithManager 创建
 }
 
 // 创建GVR, 共动态客户端使用
@@ -61,11 +87,33 @@ var (
 		Version:  "v1",
 		Resource: "certificates",
 	}
+	// httproute
+	httpRouteGVR = schema.GroupVersionResource{
+		Group:    "gateway.networking.k8s.io",
+		Version:  "v1",
+		Resource: "httproutes",
+	}
 )
 
 //+kubebuilder:rbac:groups=apps.zwh.com,resources=zwhdeployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.zwh.com,resources=zwhdeployments/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps.zwh.com,resources=zwhdeployments/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=pods;events,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// rbac.rules 生成的 Role 需要 escalate 和 bind, 能创建 ZwhDeployment 的用户可以获得 namespace 内的任意权限
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=escalate;bind
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=issuers;certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -99,92 +147,569 @@ func (r *ZwhDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	// 防止污染缓存
 	mdCopy := md.DeepCopy()
 
+	// 添加了回滚注解时, 把 spec 改为指定的历史版本, 修改后由 md 的变更触发下一次 reconcile
+	if value, ok := mdCopy.Annotations[myAppsv1.AnnotationRollbackTo]; ok {
+		return ctrl.Result{}, r.rollbackToRevision(ctx, mdCopy, value)
+	}
+
 	// 处理最终的返回
 	defer func() {
 		if r.Ready(mdCopy) {
 			_ = r.Client.Status().Update(ctx, mdCopy)
 			return
 		}
-		if mdCopy.Status.ObservedGeneration != md.Status.ObservedGeneration {
+		// 容器的重启次数和发布的进度变化时 conditions 不一定变化, 也需要提交
+		if mdCopy.Status.ObservedGeneration != md.Status.ObservedGeneration ||
+			!reflect.DeepEqual(mdCopy.Status.Containers, md.Status.Containers) ||
+			!reflect.DeepEqual(mdCopy.Status.Canary, md.Status.Canary) ||
+			!reflect.DeepEqual(mdCopy.Status.BlueGreen, md.Status.BlueGreen) ||
+			!reflect.DeepEqual(mdCopy.Status.Rollout, md.Status.Rollout) ||
+			!reflect.DeepEqual(mdCopy.Status.Images, md.Status.Images) {
 			_ = r.Client.Status().Update(ctx, mdCopy)
 		}
 	}()
 
-	// ======= 处理 deployment ======
-	// 2. 获取deployment资源对象
-	deploy := new(appsv1.Deployment)
-	if err := r.Client.Get(ctx, req.NamespacedName, deploy); err != nil {
-		if errors.IsNotFound(err) {
-			// 2.1 不存在对象
-			// 2.1.1 创建 deployment
-			if errCreate := r.createDeployment(ctx, mdCopy); err != nil {
-				return ctrl.Result{}, errCreate
+	// ======= 处理 pvc ======
+	// pvc 需要在 deployment 之前创建, 否则 pod 无法调度
+	// statefulset 的 pvc 由 volumeClaimTemplates 创建, 不需要在这里处理
+	if mdCopy.Spec.Storage != nil && len(mdCopy.Spec.Storage.Claims) > 0 &&
+		workloadKind(mdCopy) == myAppsv1.WorkloadKindDeployment {
+		message, reason, err := r.applyPVCs(ctx, mdCopy)
+		if err != nil {
+			if _, errStatus := r.updateStatus(ctx,
+				mdCopy,
+				myAppsv1.ConditionTypeStorage,
+				fmt.Sprintf("Storage %s,err:%s", req.Name, err.Error()),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonStorageNotReady); errStatus != nil {
+				return ctrl.Result{}, errStatus
 			}
+			return ctrl.Result{}, err
+		}
+		status := myAppsv1.ConditionStatusFalse
+		if message == "" {
+			// 所有 pvc 都已经绑定
+			message = fmt.Sprintf(myAppsv1.ConditionMessageStorageOKFmt, req.Name)
+			status, reason = myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonStorageReady
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeStorage,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+		// 同名的 pvc 不是 operator 创建的时候不创建或更新 deployment, 避免 pod 挂载其他人的数据
+		// 不属于 md 的 pvc 变化时不会触发 reconcile, 需要等待一段时间后重新检查
+		if reason == myAppsv1.ConditionReasonStorageConflict {
+			return ctrl.Result{RequeueAfter: WaitRequeue}, nil
+		}
+	} else {
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeStorage)
+	}
+
+	// ======= 处理 configmap 和 secret ======
+	// 配置的哈希需要在 deployment 之前计算, 写入 pod 模板的注解
+	if mdCopy.Spec.Config != nil || len(referencedConfig(mdCopy)) > 0 {
+		message, err := r.reconcileConfig(ctx, mdCopy)
+		if err != nil {
 			if _, errStatus := r.updateStatus(ctx,
 				mdCopy,
-				myAppsv1.ConditionTypeDeployment,
-				fmt.Sprintf("Deployment %s,err:%s", req.Name, err.Error()),
+				myAppsv1.ConditionTypeConfig,
+				fmt.Sprintf("Config of %s,err:%s", req.Name, err.Error()),
 				myAppsv1.ConditionStatusFalse,
-				myAppsv1.ConditionReasonDeploymentNotReady,
-			); errStatus != nil {
+				myAppsv1.ConditionReasonConfigNotFound); errStatus != nil {
 				return ctrl.Result{}, errStatus
 			}
-		} else {
+			return ctrl.Result{}, err
+		}
+		status, reason := myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonConfigNotFound
+		if message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageConfigOKFmt, req.Name)
+			status, reason = myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonConfigReady
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeConfig,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		if err := r.deleteConfigMap(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		mdCopy.Status.ConfigHash = ""
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeConfig)
+	}
+
+	// ======= 处理 serviceaccount 和 rbac ======
+	// ServiceAccount 需要在 deployment 之前创建, 否则 pod 无法创建
+	if mdCopy.Spec.ServiceAccount != nil {
+		message, reason, err := r.reconcileServiceAccount(ctx, mdCopy)
+		if err != nil {
 			if _, errStatus := r.updateStatus(ctx,
 				mdCopy,
-				myAppsv1.ConditionTypeDeployment,
-				fmt.Sprintf("Deployment %s,err:%s", req.Name, err.Error()),
+				myAppsv1.ConditionTypeServiceAccount,
+				fmt.Sprintf("ServiceAccount of %s,err:%s", req.Name, err.Error()),
 				myAppsv1.ConditionStatusFalse,
-				myAppsv1.ConditionReasonDeploymentNotReady); errStatus != nil {
+				myAppsv1.ConditionReasonSANotFound); errStatus != nil {
 				return ctrl.Result{}, errStatus
 			}
 			return ctrl.Result{}, err
 		}
+		status := myAppsv1.ConditionStatusFalse
+		if message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageSAOKFmt, serviceAccountName(mdCopy))
+			status, reason = myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonSAReady
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeServiceAccount,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+		// 同名的对象不是 operator 创建的时候不创建或更新 deployment, 避免 pod 使用其他人的 ServiceAccount 或权限
+		// 不属于 md 的对象变化时不会触发 reconcile, 需要等待一段时间后重新检查
+		if reason == myAppsv1.ConditionReasonSAConflict {
+			return ctrl.Result{RequeueAfter: WaitRequeue}, nil
+		}
 	} else {
-		//2.2存在对象
-		//2.2.1更新deployment
-		if err := r.updateDeployment(ctx, mdCopy, deploy); err != nil {
+		if _, _, err := r.reconcileServiceAccount(ctx, mdCopy); err != nil {
 			return ctrl.Result{}, err
 		}
-		if deploy.Status.AvailableReplicas == mdCopy.Spec.Replicas {
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeServiceAccount)
+	}
+
+	// ======= 处理镜像仓库凭证 ======
+	if mdCopy.Spec.RegistryCredentials != nil {
+		message, reason, err := r.reconcileRegistryCredentials(ctx, mdCopy)
+		if err != nil {
 			if _, errStatus := r.updateStatus(ctx,
 				mdCopy,
-				myAppsv1.ConditionTypeDeployment,
-				fmt.Sprintf(myAppsv1.ConditionMessageDeploymentOKFmt, req.Name),
-				myAppsv1.ConditionStatusTrue,
-				myAppsv1.ConditionReasonDeploymentReady); errStatus != nil {
+				myAppsv1.ConditionTypeRegistry,
+				fmt.Sprintf("Registry credentials of %s,err:%s", req.Name, err.Error()),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonRegistryNotFound); errStatus != nil {
+				return ctrl.Result{}, errStatus
+			}
+			return ctrl.Result{}, err
+		}
+		status := myAppsv1.ConditionStatusFalse
+		if message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageRegistryOKFmt, mdCopy.Spec.RegistryCredentials.Name, registrySecretName(mdCopy))
+			status, reason = myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonRegistryReady
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeRegistry,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+		if reason == myAppsv1.ConditionReasonRegistryConflict {
+			return ctrl.Result{RequeueAfter: WaitRequeue}, nil
+		}
+	} else {
+		// 只获取元数据, 避免启动缓存所有 secret 的 informer
+		registry := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: registrySecretName(mdCopy)}}
+		registry.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+		if err := r.deleteOwned(ctx, mdCopy, registry); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeRegistry)
+	}
+
+	// ======= 解析镜像摘要 ======
+	// 摘要需要在 deployment 之前解析, 解析失败时不使用 tag 创建或更新 pod, 避免不同的 pod 运行不同的镜像
+	if mdCopy.Spec.ImagePolicy == myAppsv1.ImagePolicyPinDigest {
+		digest, err := r.resolveImage(ctx, mdCopy)
+		if err != nil {
+			if _, errStatus := r.updateStatus(ctx,
+				mdCopy,
+				myAppsv1.ConditionTypeImage,
+				fmt.Sprintf(myAppsv1.ConditionMessageImageNotFmt, mdCopy.Spec.Image, err.Error()),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonImageResolveFailed); errStatus != nil {
 				return ctrl.Result{}, errStatus
 			}
+			return ctrl.Result{}, err
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeImage,
+			fmt.Sprintf(myAppsv1.ConditionMessageImageOKFmt, mdCopy.Spec.Image, digest),
+			myAppsv1.ConditionStatusTrue,
+			myAppsv1.ConditionReasonImagePinned); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		mdCopy.Status.Images = nil
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeImage)
+	}
+
+	// ======= 处理 deployment/statefulset ======
+	// 记录 pod 中所有容器的资源对应的 QoS 等级, 随后面的状态更新一起提交
+	template, err := newPodTemplate(mdCopy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	mdCopy.Status.QOSClass = qosClass(&template.Spec)
+
+	// ======= 记录 spec 的历史版本 ======
+	// 镜像摘要解析之后记录, 版本中保存 pod 模板实际使用的镜像
+	if err := r.reconcileRevisions(ctx, mdCopy, template); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// ======= 检查 pod 的安全配置 ======
+	// 不满足 securityProfile 时不创建或更新 deployment/statefulset, 已经运行的 pod 不受影响, 修改 spec 后重新检查
+	if profile := mdCopy.Spec.SecurityProfile; profile != "" {
+		if violations := securityViolations(profile, &template.Spec); len(violations) > 0 {
+			if _, errStatus := r.updateStatus(ctx,
+				mdCopy,
+				myAppsv1.ConditionTypePodSecurity,
+				fmt.Sprintf(myAppsv1.ConditionMessageSecurityNotFmt, req.Name, profile, strings.Join(violations, "; ")),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonSecurityViolation); errStatus != nil {
+				return ctrl.Result{}, errStatus
+			}
+			return ctrl.Result{}, nil
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypePodSecurity,
+			fmt.Sprintf(myAppsv1.ConditionMessageSecurityOKFmt, req.Name, profile),
+			myAppsv1.ConditionStatusTrue,
+			myAppsv1.ConditionReasonSecurityCompliant); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypePodSecurity)
+	}
+
+	if workloadKind(mdCopy) == myAppsv1.WorkloadKindStatefulSet {
+		// workloadKind 为 StatefulSet 时, 删除之前可能创建的 deployment
+		if err := r.deleteDeployment(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeDeployment)
+		// statefulset 不支持金丝雀发布和蓝绿发布
+		if err := r.stopCanary(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.stopBlueGreen(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.reconcileStatefulSet(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		// workloadKind 为 Deployment 时, 删除之前可能创建的 statefulset 和 headless service
+		if err := r.deleteStatefulSet(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeStatefulSet)
+
+		// 2. 获取deployment资源对象
+		deploy := new(appsv1.Deployment)
+		if err := r.Client.Get(ctx, req.NamespacedName, deploy); err != nil {
+			if errors.IsNotFound(err) {
+				// 2.1 不存在对象
+				// 2.1.1 创建 deployment, 金丝雀发布没有完成时使用稳定版本的镜像
+				if errCreate := r.createDeployment(ctx, stableZwhDeployment(mdCopy)); errCreate != nil {
+					return ctrl.Result{}, errCreate
+				}
+				if _, errStatus := r.updateStatus(ctx,
+					mdCopy,
+					myAppsv1.ConditionTypeDeployment,
+					fmt.Sprintf("Deployment %s,err:%s", req.Name, err.Error()),
+					myAppsv1.ConditionStatusFalse,
+					myAppsv1.ConditionReasonDeploymentNotReady,
+				); errStatus != nil {
+					return ctrl.Result{}, errStatus
+				}
+			} else {
+				if _, errStatus := r.updateStatus(ctx,
+					mdCopy,
+					myAppsv1.ConditionTypeDeployment,
+					fmt.Sprintf("Deployment %s,err:%s", req.Name, err.Error()),
+					myAppsv1.ConditionStatusFalse,
+					myAppsv1.ConditionReasonDeploymentNotReady); errStatus != nil {
+					return ctrl.Result{}, errStatus
+				}
+				return ctrl.Result{}, err
+			}
 		} else {
+			//2.2存在对象
+			//2.2.1开启金丝雀发布或蓝绿发布时先处理新版本, 推广之前稳定版本保持原来的镜像
+			if err := r.reconcileCanary(ctx, mdCopy, deploy); err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.reconcileBlueGreen(ctx, mdCopy, deploy); err != nil {
+				return ctrl.Result{}, err
+			}
+			//2.2.2更新deployment
+			if deploy, err = r.updateDeployment(ctx, stableZwhDeployment(mdCopy), deploy); err != nil {
+				return ctrl.Result{}, err
+			}
+			//2.2.3超过 progressDeadlineSeconds 没有完成时发布失败, 开启 autoRollback 时回滚到上一次成功发布的 pod 模板
+			if updateRollout(mdCopy, deploy) {
+				rollout := mdCopy.Status.Rollout
+				r.Recorder.Eventf(md, corev1.EventTypeWarning, myAppsv1.ConditionReasonRolloutFailed,
+					"Image %s failed to roll out: %s", rollout.FailedImage, rollout.Message)
+				if mdCopy.Spec.AutoRollback && rollout.LastGoodTemplate != nil {
+					rollout.Phase = myAppsv1.RolloutPhaseRolledBack
+					if deploy, err = r.updateDeployment(ctx, stableZwhDeployment(mdCopy), deploy); err != nil {
+						return ctrl.Result{}, err
+					}
+					r.Recorder.Eventf(md, corev1.EventTypeWarning, myAppsv1.ConditionReasonRolledBack,
+						"Rolled back from image %s to %s", rollout.FailedImage, rollout.LastGoodImage)
+				}
+			}
+			if rolloutFailed(mdCopy) {
+				rollout := mdCopy.Status.Rollout
+				message := fmt.Sprintf(myAppsv1.ConditionMessageRolloutFailedFmt, req.Name, rollout.FailedImage, rollout.Message)
+				reason := myAppsv1.ConditionReasonRolloutFailed
+				if rollout.Phase == myAppsv1.RolloutPhaseRolledBack {
+					message = fmt.Sprintf(myAppsv1.ConditionMessageRolledBackFmt, req.Name, rollout.LastGoodImage, rollout.FailedImage, rollout.Message)
+					reason = myAppsv1.ConditionReasonRolledBack
+				}
+				if _, errStatus := r.updateStatus(ctx,
+					mdCopy,
+					myAppsv1.ConditionTypeDeployment,
+					message,
+					myAppsv1.ConditionStatusFalse,
+					reason); errStatus != nil {
+					return ctrl.Result{}, errStatus
+				}
+			} else if deploy.Status.AvailableReplicas == desiredReplicas(mdCopy, deploy.Spec.Replicas) {
+				if _, errStatus := r.updateStatus(ctx,
+					mdCopy,
+					myAppsv1.ConditionTypeDeployment,
+					fmt.Sprintf(myAppsv1.ConditionMessageDeploymentOKFmt, req.Name),
+					myAppsv1.ConditionStatusTrue,
+					myAppsv1.ConditionReasonDeploymentReady); errStatus != nil {
+					return ctrl.Result{}, errStatus
+				}
+			} else {
+				//2.2.2没有就绪,检查是不是健康检查失败导致的
+				message := fmt.Sprintf(myAppsv1.ConditionMessageDeploymentNotFmt, req.Name)
+				reason := myAppsv1.ConditionReasonDeploymentNotReady
+				failure, err := r.probeFailure(ctx, mdCopy)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+				if failure != "" {
+					message = fmt.Sprintf("%s: %s", message, failure)
+					reason = myAppsv1.ConditionReasonProbeFailed
+				}
+				if _, errStatus := r.updateStatus(ctx,
+					mdCopy,
+					myAppsv1.ConditionTypeDeployment,
+					message,
+					myAppsv1.ConditionStatusFalse,
+					reason); errStatus != nil {
+					return ctrl.Result{}, errStatus
+				}
+			}
+		}
+	}
+
+	// ======= 处理金丝雀发布的状态 =========
+	if mdCopy.Status.Canary != nil {
+		message, status, reason := canaryCondition(mdCopy)
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeCanary,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeCanary)
+	}
+
+	// ======= 处理蓝绿发布的状态 =========
+	if mdCopy.Status.BlueGreen != nil {
+		message, status, reason := blueGreenCondition(mdCopy)
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeBlueGreen,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeBlueGreen)
+	}
+
+	// 记录每个容器的重启次数和等待原因, 随后面的状态更新一起提交
+	if err := r.updateContainerStatuses(ctx, mdCopy); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// ======= 处理 hpa =========
+	// 开启自动扩缩容时, 副本数由 hpa 控制, 更新 deployment/statefulset 时保留线上的副本数
+	if autoscalingEnabled(mdCopy) {
+		hpa := new(autoscalingv2.HorizontalPodAutoscaler)
+		if err := r.Client.Get(ctx, req.NamespacedName, hpa); err != nil {
+			if !errors.IsNotFound(err) {
+				if _, errStatus := r.updateStatus(ctx,
+					mdCopy,
+					myAppsv1.ConditionTypeHPA,
+					fmt.Sprintf("HorizontalPodAutoscaler %s,err:%s", req.Name, err.Error()),
+					myAppsv1.ConditionStatusFalse,
+					myAppsv1.ConditionReasonHPANotActive); errStatus != nil {
+					return ctrl.Result{}, errStatus
+				}
+				return ctrl.Result{}, err
+			}
+			if err := r.createHPA(ctx, mdCopy); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else if err := r.updateHPA(ctx, mdCopy, hpa); err != nil {
+			return ctrl.Result{}, err
+		}
+		mdCopy.Status.CurrentReplicas = hpa.Status.CurrentReplicas
+		mdCopy.Status.DesiredReplicas = hpa.Status.DesiredReplicas
+		active, message := hpaActive(hpa)
+		status, reason := myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonHPANotActive
+		if active {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageHPAOKFmt, req.Name)
+			status, reason = myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonHPAActive
+		} else if message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageHPANotFmt, req.Name)
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeHPA,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		if err := r.deleteHPA(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		mdCopy.Status.CurrentReplicas = 0
+		mdCopy.Status.DesiredReplicas = 0
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeHPA)
+	}
+
+	// ======= 处理 pdb =========
+	if pdbEnabled(mdCopy) {
+		pdb := new(policyv1.PodDisruptionBudget)
+		if err := r.Client.Get(ctx, req.NamespacedName, pdb); err != nil {
+			if !errors.IsNotFound(err) {
+				if _, errStatus := r.updateStatus(ctx,
+					mdCopy,
+					myAppsv1.ConditionTypePDB,
+					fmt.Sprintf("PodDisruptionBudget %s,err:%s", req.Name, err.Error()),
+					myAppsv1.ConditionStatusFalse,
+					myAppsv1.ConditionReasonPDBNotReady); errStatus != nil {
+					return ctrl.Result{}, errStatus
+				}
+				return ctrl.Result{}, err
+			}
+			if err := r.createPDB(ctx, mdCopy); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else if err := r.updatePDB(ctx, mdCopy, pdb); err != nil {
+			return ctrl.Result{}, err
+		}
+		// pdb 的 controller 还没有计算过状态, 或者健康的 pod 数量不够时, 驱逐会被拒绝
+		message := fmt.Sprintf(myAppsv1.ConditionMessagePDBOKFmt, req.Name)
+		status, reason := myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonPDBReady
+		if pdb.Status.ObservedGeneration == 0 || pdb.Status.CurrentHealthy < pdb.Status.DesiredHealthy {
+			message = fmt.Sprintf(myAppsv1.ConditionMessagePDBNotFmt, req.Name, pdb.Status.CurrentHealthy, pdb.Status.DesiredHealthy)
+			status, reason = myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonPDBNotReady
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypePDB,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		if err := r.deletePDB(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypePDB)
+	}
+
+	// ======= 处理 networkpolicy =========
+	if np := NewNetworkPolicy(mdCopy); np != nil {
+		message, reason, err := r.applyNetworkPolicy(ctx, mdCopy, np)
+		if err != nil {
 			if _, errStatus := r.updateStatus(ctx,
 				mdCopy,
-				myAppsv1.ConditionTypeDeployment,
-				fmt.Sprintf(myAppsv1.ConditionMessageDeploymentNotFmt, req.Name),
+				myAppsv1.ConditionTypeNetworkPolicy,
+				fmt.Sprintf("NetworkPolicy %s,err:%s", req.Name, err.Error()),
 				myAppsv1.ConditionStatusFalse,
-				myAppsv1.ConditionReasonDeploymentNotReady); errStatus != nil {
+				myAppsv1.ConditionReasonPolicyNotReady); errStatus != nil {
 				return ctrl.Result{}, errStatus
 			}
+			return ctrl.Result{}, err
+		}
+		status := myAppsv1.ConditionStatusFalse
+		if message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessagePolicyOKFmt, req.Name)
+			status, reason = myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonPolicyReady
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeNetworkPolicy,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+		if reason == myAppsv1.ConditionReasonPolicyConflict {
+			return ctrl.Result{RequeueAfter: WaitRequeue}, nil
+		}
+	} else {
+		if err := r.deleteOwned(ctx, mdCopy, &networkv1.NetworkPolicy{}); err != nil {
+			return ctrl.Result{}, err
 		}
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeNetworkPolicy)
 	}
 
 	// ======= 处理 service =========
 	// 3. 获取 service 资源对象
+	mode := strings.ToLower(mdCopy.Spec.Expose.Mode)
 	svc := new(corev1.Service)
 	if err := r.Client.Get(ctx, req.NamespacedName, svc); err != nil {
 		if errors.IsNotFound(err) {
 			// 3.1 不存在 创建 service
-			//3.1.1mode为ingress
-			if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeIngress {
+			//3.1.1mode为ingress、gateway或clusterip
+			if mode == myAppsv1.ModeIngress || mode == myAppsv1.ModeGateway || mode == myAppsv1.ModeClusterIP {
 				//3.1.1.1创建普通service
 				if err := r.createService(ctx, mdCopy); err != nil {
 					return ctrl.Result{}, err
 				}
-			} else if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeNodePort {
+			} else if mode == myAppsv1.ModeNodePort {
 				//mode为nodeport
 				//3.1.2.1创建 nodeport模式的 service
 				if err := r.createNPService(ctx, mdCopy); err != nil {
 					return ctrl.Result{}, err
 				}
+			} else if mode == myAppsv1.ModeLoadBalancer {
+				//mode为loadbalancer
+				//3.1.3.1创建 loadbalancer模式的 service
+				if err := r.createLBService(ctx, mdCopy); err != nil {
+					return ctrl.Result{}, err
+				}
 			} else {
 				return ctrl.Result{}, myAppsv1.ErrorNotSupportMode
 			}
@@ -209,22 +734,42 @@ func (r *ZwhDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 	} else {
 		//3.2存在
-		if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeIngress {
-			//3.2.1 mode为ingress
+		if mode == myAppsv1.ModeIngress || mode == myAppsv1.ModeGateway || mode == myAppsv1.ModeClusterIP {
+			//3.2.1 mode为ingress、gateway或clusterip
 			//3.2.1.1更新普通的service
 			if err := r.updateService(ctx, mdCopy, svc); err != nil {
 				return ctrl.Result{}, err
 			}
-		} else if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeNodePort {
+		} else if mode == myAppsv1.ModeNodePort {
 			//3.2.2 mode为nodeport
 			//3.2.2.1更新nodeport模式的service
 			if err := r.updateNPSerive(ctx, mdCopy, svc); err != nil {
 				return ctrl.Result{}, err
 			}
+		} else if mode == myAppsv1.ModeLoadBalancer {
+			//3.2.3 mode为loadbalancer
+			//3.2.3.1更新loadbalancer模式的service
+			if err := r.updateLBService(ctx, mdCopy, svc); err != nil {
+				return ctrl.Result{}, err
+			}
 		} else {
 			return ctrl.Result{}, myAppsv1.ErrorNotSupportMode
 		}
-		if _, errStatus := r.updateStatus(ctx,
+		//3.2.4 loadbalancer模式需要等待分配外部地址
+		mdCopy.Status.ExternalAddress = ""
+		if mode == myAppsv1.ModeLoadBalancer {
+			mdCopy.Status.ExternalAddress = lbAddress(svc)
+		}
+		if mode == myAppsv1.ModeLoadBalancer && mdCopy.Status.ExternalAddress == "" {
+			if _, errStatus := r.updateStatus(ctx,
+				mdCopy,
+				myAppsv1.ConditionTypeService,
+				fmt.Sprintf(myAppsv1.ConditionMessageServiceLBFmt, req.Name),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonServiceNotReady); errStatus != nil {
+				return ctrl.Result{}, errStatus
+			}
+		} else if _, errStatus := r.updateStatus(ctx,
 			mdCopy,
 			myAppsv1.ConditionTypeService,
 			fmt.Sprintf(myAppsv1.ConditionMessageServiceOKFmt, req.Name),
@@ -244,7 +789,7 @@ func (r *ZwhDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	if err := r.Client.Get(ctx, req.NamespacedName, ig); err != nil {
 		if errors.IsNotFound(err) {
 			// 4.1 不存在
-			if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeIngress {
+			if mode == myAppsv1.ModeIngress {
 				// 4.1.1 mode 为 ingress
 				// 4.1.1.1 创建 ingress
 				if err := r.createIngress(ctx, mdCopy); err != nil {
@@ -258,10 +803,9 @@ func (r *ZwhDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 					myAppsv1.ConditionReasonIngressNotReady); errStatus != nil {
 					return ctrl.Result{}, errStatus
 				}
-			} else if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeNodePort {
-				//4.1.2mode为nodeport
-				//4.1.2.1退出
-				return ctrl.Result{}, nil
+			} else {
+				//4.1.2mode为nodeport、loadbalancer、clusterip或gateway
+				//4.1.2.1不需要ingress,继续处理证书
 			}
 		} else {
 			if _, errStatus := r.updateStatus(ctx,
@@ -277,7 +821,7 @@ func (r *ZwhDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 
 	} else {
 		//4,2存在
-		if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeIngress {
+		if mode == myAppsv1.ModeIngress {
 			//4.2.1 mode为ingress
 			//4,2,1,1 更新ingress
 			if err := r.updateIngress(ctx, mdCopy, ig); err != nil {
@@ -291,75 +835,1497 @@ func (r *ZwhDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 				myAppsv1.ConditionReasonIngressReady); errStatus != nil {
 				return ctrl.Result{}, errStatus
 			}
-		} else if strings.ToLower(mdCopy.Spec.Expose.Mode) == myAppsv1.ModeNodePort {
-			//4,2,2 mode 为nodeport
-			// 4.2.2.1删除ingress
+		} else {
+			//4,2,2 mode 为nodeport、loadbalancer、clusterip或gateway
+			// 4.2.2.1删除ingress, 用户自己创建的同名ingress保留
 			if err := r.deleteIngress(ctx, mdCopy); err != nil {
 				return ctrl.Result{}, err
 			}
 			r.deleteStatus(mdCopy, myAppsv1.ConditionTypeIngress)
 		}
 	}
-	//最后检查状态时候最终完成
-	if sus, errStatus := r.updateStatus(ctx,
-		mdCopy,
-		"",
-		"",
-		"",
-		""); errStatus != nil {
-		return ctrl.Result{}, errStatus
-	} else if !sus {
-		logger.Info("reconcile is ended")
-		return ctrl.Result{RequeueAfter: WaitRequeue}, nil
+	//4.3 需要重写路径的规则放在单独的ingress中
+	if err := r.applyRewriteIngress(ctx, mdCopy, mode); err != nil {
+		return ctrl.Result{}, err
+	}
+	//======处理 tls 证书 ==========
+	//5 mode为ingress并且开启了tls,证书不是用户提供的时候,需要issuer和certificate
+	if tlsEnabled(mdCopy) && tlsIssuer(mdCopy) != myAppsv1.TlsIssuerSecret {
+		//5.1 处理issuer,只有selfsigned需要自己创建
+		if tlsIssuer(mdCopy) == myAppsv1.TlsIssuerSelfSigned {
+			if err := r.applyIssuer(ctx, mdCopy); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else if err := r.deleteIssuer(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		//5.2 处理certificate
+		if err := r.applyCert(ctx, mdCopy); err != nil {
+			if _, errStatus := r.updateStatus(ctx,
+				mdCopy,
+				myAppsv1.ConditionTypeCertificate,
+				fmt.Sprintf("Certificate %s,err: %s", req.Name, err.Error()),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonCertNotReady); errStatus != nil {
+				return ctrl.Result{}, errStatus
+			}
+			return ctrl.Result{}, err
+		}
+		//5.3 根据cert-manager的签发结果更新状态
+		ready, message, err := r.certReady(ctx, mdCopy)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if ready {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageCertOKFmt, req.Name)
+		} else if message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageCertNotFmt, req.Name)
+		}
+		status, reason := myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonCertNotReady
+		if ready {
+			status, reason = myAppsv1.ConditionStatusTrue, myAppsv1.ConditionReasonCertReady
+		}
+		if _, errStatus := r.updateStatus(ctx,
+			mdCopy,
+			myAppsv1.ConditionTypeCertificate,
+			message,
+			status,
+			reason); errStatus != nil {
+			return ctrl.Result{}, errStatus
+		}
+	} else {
+		//5.4 不需要证书,删除issuer和certificate
+		if err := r.deleteCert(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.deleteIssuer(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeCertificate)
+	}
+
+	//======处理 httproute ==========
+	//6 mode为gateway时使用HTTPRoute代替ingress
+	if mode == myAppsv1.ModeGateway {
+		//6.1 创建或更新httproute
+		route, err := r.applyHTTPRoute(ctx, mdCopy)
+		if err != nil {
+			if _, errStatus := r.updateStatus(ctx,
+				mdCopy,
+				myAppsv1.ConditionTypeRouteAccepted,
+				fmt.Sprintf("HTTPRoute %s,err: %s", req.Name, err.Error()),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonRoutePending); errStatus != nil {
+				return ctrl.Result{}, errStatus
+			}
+			return ctrl.Result{}, err
+		}
+		//6.2 把gateway控制器写入的Accepted和ResolvedRefs同步到status
+		for _, c := range []struct{ conditionType, routeConditionType string }{
+			{myAppsv1.ConditionTypeRouteAccepted, "Accepted"},
+			{myAppsv1.ConditionTypeRouteResolvedRefs, "ResolvedRefs"},
+		} {
+			conditionType := c.conditionType
+			status, reason, message, found := routeCondition(route, c.routeConditionType)
+			if !found {
+				status, reason = myAppsv1.ConditionStatusFalse, myAppsv1.ConditionReasonRoutePending
+				message = fmt.Sprintf(myAppsv1.ConditionMessageRouteNotFmt, req.Name)
+			}
+			if _, errStatus := r.updateStatus(ctx,
+				mdCopy,
+				conditionType,
+				message,
+				status,
+				reason); errStatus != nil {
+				return ctrl.Result{}, errStatus
+			}
+		}
+	} else {
+		//6.3 不是gateway模式,删除httproute
+		if err := r.deleteHTTPRoute(ctx, mdCopy); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeRouteAccepted)
+		r.deleteStatus(mdCopy, myAppsv1.ConditionTypeRouteResolvedRefs)
+	}
+
+	//最后检查状态时候最终完成
+	if sus, errStatus := r.updateStatus(ctx,
+		mdCopy,
+		"",
+		"",
+		"",
+		""); errStatus != nil {
+		return ctrl.Result{}, errStatus
+	} else if !sus && waitRequeue(mdCopy.Status.Conditions) {
+		logger.Info("reconcile is ended")
+		return ctrl.Result{RequeueAfter: WaitRequeue}, nil
+	}
+	logger.Info("reconcile is ended")
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ZwhDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// 按照引用的 configmap 和 secret 建立索引, 变化时不需要遍历所有的 md
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &myAppsv1.ZwhDeployment{}, configRefIndex,
+		func(obj client.Object) []string {
+			return configRefKeys(obj.(*myAppsv1.ZwhDeployment))
+		}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &myAppsv1.ZwhDeployment{}, registryCredentialsIndex,
+		func(obj client.Object) []string {
+			if ref := obj.(*myAppsv1.ZwhDeployment).Spec.RegistryCredentials; ref != nil {
+				return []string{ref.Name}
+			}
+			return nil
+		}); err != nil {
+		return err
+	}
+	b := ctrl.NewControllerManagedBy(mgr)
+	// 集中管理的镜像仓库凭证使用单独的缓存, 只缓存这个 namespace 中的 secret
+	if r.RegistryNamespace != "" {
+		registryCache, err := cache.New(mgr.GetConfig(), cache.Options{
+			HTTPClient: mgr.GetHTTPClient(),
+			Scheme:     mgr.GetScheme(),
+			Mapper:     mgr.GetRESTMapper(),
+			Namespaces: []string{r.RegistryNamespace},
+		})
+		if err != nil {
+			return err
+		}
+		if err := mgr.Add(registryCache); err != nil {
+			return err
+		}
+		r.registryCache = registryCache
+		b = b.WatchesRawSource(source.Kind(registryCache, &corev1.Secret{}), handler.EnqueueRequestsFromMapFunc(r.findRegistryCredentials))
+	}
+	// 集群安装了 Gateway API 时才监控 httproute, 否则 manager 会因为找不到资源类型而启动失败
+	routeGVK := httpRouteGVR.GroupVersion().WithKind("HTTPRoute")
+	if _, err := mgr.GetRESTMapper().RESTMapping(routeGVK.GroupKind(), routeGVK.Version); err == nil {
+		route := new(unstructured.Unstructured)
+		route.SetGroupVersionKind(routeGVK)
+		b = b.Owns(route) //监控httproute类型，gateway控制器更新状态时触发reconciler
+	}
+	return b.
+		For(&myAppsv1.ZwhDeployment{}).
+		Owns(&appsv1.Deployment{}).                     //监控deployment类型，变更就触发reconciler
+		Owns(&appsv1.StatefulSet{}).                    //监控statefulset类型，变更就触发reconciler
+		Owns(&corev1.Service{}).                        //监控service类型，变更就触发reconciler
+		Owns(&networkv1.Ingress{}).                     //监控ingress类型，变更就触发reconciler
+		Owns(&corev1.PersistentVolumeClaim{}).          //监控pvc类型，reclaimPolicy为delete时变更就触发reconciler
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}). //监控hpa类型，副本数变化时更新status
+		Owns(&policyv1.PodDisruptionBudget{}).          //监控pdb类型，变更就触发reconciler
+		Owns(&corev1.ServiceAccount{}).                 //监控serviceaccount类型，变更就触发reconciler
+		Owns(&rbacv1.Role{}).                           //监控role类型，变更就触发reconciler
+		Owns(&rbacv1.RoleBinding{}).                    //监控rolebinding类型，变更就触发reconciler
+		Owns(&networkv1.NetworkPolicy{}).               //监控networkpolicy类型，变更就触发reconciler
+		Owns(&corev1.Secret{}, builder.OnlyMetadata).   //监控复制的镜像仓库凭证，被修改或删除时重新复制
+		// configmap 只缓存元数据, 避免缓存集群中所有 configmap 的内容, 内容通过 APIReader 读取
+		Owns(&corev1.ConfigMap{}, builder.OnlyMetadata). //监控configmap类型，config变更就触发reconciler
+		// 引用的已有 configmap 和 secret 不属于 md, 内容变化时找到引用它的 md 重新计算哈希
+		// 同样只缓存元数据, 内容通过 APIReader 读取
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.findReferencing("ConfigMap")),
+			builder.OnlyMetadata, builder.WithPredicates(r.referencedPredicate("ConfigMap"))).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findReferencing("Secret")),
+			builder.OnlyMetadata, builder.WithPredicates(r.referencedPredicate("Secret"))).
+		Complete(r)
+}
+
+func (r *ZwhDeploymentReconciler) createDeployment(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	deploy, err := NewDeployment(md)
+	if err != nil {
+		return err
+	}
+
+	//设置deployment所属于md
+	if err := controllerutil.SetControllerReference(md, deploy, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, deploy)
+
+}
+
+// updateDeployment 更新 deployment, 返回更新后线上的对象
+func (r *ZwhDeploymentReconciler) updateDeployment(ctx context.Context, md *myAppsv1.ZwhDeployment, dp *appsv1.Deployment) (*appsv1.Deployment, error) {
+	deploy, err := NewDeployment(md)
+
+	if err != nil {
+		return nil, err
+	}
+	// 设置 deployment 所属于 md
+	if err := controllerutil.SetControllerReference(md, deploy, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	// 开启自动扩缩容时, 保留 hpa 调整后的副本数
+	if autoscalingEnabled(md) {
+		deploy.Spec.Replicas = dp.Spec.Replicas
+	}
+	// 发布失败并回滚后, 使用上一次成功发布的 pod 模板
+	if template := rollbackTemplate(md); template != nil {
+		deploy.Spec.Template = *template.DeepCopy()
+	}
+
+	//预更新deployment。得到更新后的数据
+	if err := r.Update(ctx, deploy, client.DryRunAll); err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(dp.Spec, deploy.Spec) {
+		return dp, nil
+	}
+	return deploy, r.Client.Update(ctx, deploy)
+
+}
+
+// reconcileStatefulSet 处理 statefulset 和它使用的 headless service, 并更新 StatefulSet 的状态
+func (r *ZwhDeploymentReconciler) reconcileStatefulSet(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	// 1. 先处理 headless service, statefulset 创建时需要
+	headless := new(corev1.Service)
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: md.Namespace, Name: md.Name + "-headless"}, headless); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		if err := r.createHeadlessService(ctx, md); err != nil {
+			return err
+		}
+	} else if err := r.updateHeadlessService(ctx, md, headless); err != nil {
+		return err
+	}
+
+	// 2. 处理 statefulset
+	sts := new(appsv1.StatefulSet)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(md), sts); err != nil {
+		if !errors.IsNotFound(err) {
+			if _, errStatus := r.updateStatus(ctx,
+				md,
+				myAppsv1.ConditionTypeStatefulSet,
+				fmt.Sprintf("StatefulSet %s,err:%s", md.Name, err.Error()),
+				myAppsv1.ConditionStatusFalse,
+				myAppsv1.ConditionReasonStatefulSetNotReady); errStatus != nil {
+				return errStatus
+			}
+			return err
+		}
+		// 2.1 不存在, 创建 statefulset
+		if err := r.createStatefulSet(ctx, md); err != nil {
+			return err
+		}
+		_, err := r.updateStatus(ctx,
+			md,
+			myAppsv1.ConditionTypeStatefulSet,
+			fmt.Sprintf(myAppsv1.ConditionMessageStatefulSetNotFmt, md.Name),
+			myAppsv1.ConditionStatusFalse,
+			myAppsv1.ConditionReasonStatefulSetNotReady)
+		return err
+	}
+
+	// 2.2 存在, 更新 statefulset
+	if err := r.updateStatefulSet(ctx, md, sts); err != nil {
+		return err
+	}
+	if sts.Status.ReadyReplicas == desiredReplicas(md, sts.Spec.Replicas) {
+		_, err := r.updateStatus(ctx,
+			md,
+			myAppsv1.ConditionTypeStatefulSet,
+			fmt.Sprintf(myAppsv1.ConditionMessageStatefulSetOKFmt, md.Name),
+			myAppsv1.ConditionStatusTrue,
+			myAppsv1.ConditionReasonStatefulSetReady)
+		return err
+	}
+	// 2.3 没有就绪, 检查是不是健康检查失败导致的
+	message := fmt.Sprintf(myAppsv1.ConditionMessageStatefulSetNotFmt, md.Name)
+	reason := myAppsv1.ConditionReasonStatefulSetNotReady
+	failure, err := r.probeFailure(ctx, md)
+	if err != nil {
+		return err
+	}
+	if failure != "" {
+		message = fmt.Sprintf("%s: %s", message, failure)
+		reason = myAppsv1.ConditionReasonProbeFailed
+	}
+	_, err = r.updateStatus(ctx,
+		md,
+		myAppsv1.ConditionTypeStatefulSet,
+		message,
+		myAppsv1.ConditionStatusFalse,
+		reason)
+	return err
+}
+
+func (r *ZwhDeploymentReconciler) createStatefulSet(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	sts, err := NewStatefulSet(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, sts, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, sts)
+}
+
+func (r *ZwhDeploymentReconciler) updateStatefulSet(ctx context.Context, md *myAppsv1.ZwhDeployment, old *appsv1.StatefulSet) error {
+	sts, err := NewStatefulSet(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, sts, r.Scheme); err != nil {
+		return err
+	}
+	// statefulset 只有 replicas, template, updateStrategy 等字段可以修改, 其他字段保持线上的值
+	sts.Spec.Selector = old.Spec.Selector
+	sts.Spec.ServiceName = old.Spec.ServiceName
+	sts.Spec.VolumeClaimTemplates = old.Spec.VolumeClaimTemplates
+	sts.Spec.PodManagementPolicy = old.Spec.PodManagementPolicy
+	// 开启自动扩缩容时, 保留 hpa 调整后的副本数
+	if autoscalingEnabled(md) {
+		sts.Spec.Replicas = old.Spec.Replicas
+	}
+
+	//预更新statefulset。得到更新后的数据
+	if err := r.Update(ctx, sts, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, sts.Spec) {
+		return nil
+	}
+	return r.Client.Update(ctx, sts)
+}
+
+// deleteStatefulSet 需要是幂等的, 同时删除 headless service
+func (r *ZwhDeploymentReconciler) deleteStatefulSet(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	if err := r.deleteOwned(ctx, md, &appsv1.StatefulSet{}); err != nil {
+		return err
+	}
+	headless := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: md.Name + "-headless"}}
+	return r.deleteOwned(ctx, md, headless)
+}
+
+// deleteDeployment 需要是幂等的, 不存在或者不是 operator 创建的时候什么也不做
+func (r *ZwhDeploymentReconciler) deleteDeployment(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	return r.deleteOwned(ctx, md, &appsv1.Deployment{})
+}
+
+func (r *ZwhDeploymentReconciler) createHeadlessService(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	svc, err := NewHeadlessService(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, svc, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, svc)
+}
+
+func (r *ZwhDeploymentReconciler) updateHeadlessService(ctx context.Context, md *myAppsv1.ZwhDeployment, service *corev1.Service) error {
+	svc, err := NewHeadlessService(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, svc, r.Scheme); err != nil {
+		return err
+	}
+	//预更新service。得到更新后的数据
+	if err := r.Update(ctx, svc, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(service.Spec, svc.Spec) {
+		return nil
+	}
+	return r.Client.Update(ctx, svc)
+}
+
+func (r *ZwhDeploymentReconciler) createHPA(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	hpa, err := NewHPA(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, hpa, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, hpa)
+}
+
+func (r *ZwhDeploymentReconciler) updateHPA(ctx context.Context, md *myAppsv1.ZwhDeployment, old *autoscalingv2.HorizontalPodAutoscaler) error {
+	hpa, err := NewHPA(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, hpa, r.Scheme); err != nil {
+		return err
+	}
+	//预更新hpa。得到更新后的数据
+	if err := r.Update(ctx, hpa, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, hpa.Spec) {
+		return nil
+	}
+	return r.Client.Update(ctx, hpa)
+}
+
+// deleteHPA 需要是幂等的, 不存在时什么也不做
+// 用户自己创建的同名 hpa 不能删除
+func (r *ZwhDeploymentReconciler) deleteHPA(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	return r.deleteOwned(ctx, md, &autoscalingv2.HorizontalPodAutoscaler{})
+}
+
+func (r *ZwhDeploymentReconciler) createPDB(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	pdb, err := NewPDB(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, pdb, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, pdb)
+}
+
+func (r *ZwhDeploymentReconciler) updatePDB(ctx context.Context, md *myAppsv1.ZwhDeployment, old *policyv1.PodDisruptionBudget) error {
+	pdb, err := NewPDB(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, pdb, r.Scheme); err != nil {
+		return err
+	}
+	//预更新pdb。得到更新后的数据
+	if err := r.Update(ctx, pdb, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, pdb.Spec) {
+		return nil
+	}
+	return r.Client.Update(ctx, pdb)
+}
+
+// deletePDB 需要是幂等的, 不存在时什么也不做
+// 用户自己创建的同名 pdb 不能删除, 例如单副本的应用
+func (r *ZwhDeploymentReconciler) deletePDB(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	return r.deleteOwned(ctx, md, &policyv1.PodDisruptionBudget{})
+}
+
+// hpaActive 读取 hpa 的 ScalingActive condition, 判断是否能正常获取指标并计算副本数
+func hpaActive(hpa *autoscalingv2.HorizontalPodAutoscaler) (bool, string) {
+	for _, condition := range hpa.Status.Conditions {
+		if condition.Type == autoscalingv2.ScalingActive {
+			return condition.Status == corev1.ConditionTrue, condition.Message
+		}
+	}
+	return false, ""
+}
+
+// desiredReplicas 开启自动扩缩容时, 期望的副本数以线上被 hpa 调整后的为准
+func desiredReplicas(md *myAppsv1.ZwhDeployment, replicas *int32) int32 {
+	if autoscalingEnabled(md) && replicas != nil {
+		return *replicas
+	}
+	return md.Spec.Replicas
+}
+
+// applyPVCs 创建或更新 storage 中的 pvc, 返回第一个还没有绑定的 pvc 的信息和 condition 的 reason
+// 同名的 pvc 不是 operator 创建的时候不修改它, 返回冲突的信息
+func (r *ZwhDeploymentReconciler) applyPVCs(ctx context.Context, md *myAppsv1.ZwhDeployment) (string, string, error) {
+	message, reason := "", myAppsv1.ConditionReasonStorageNotReady
+	for _, pvc := range NewPVCs(md) {
+		old := new(corev1.PersistentVolumeClaim)
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(pvc), old); err != nil {
+			if !errors.IsNotFound(err) {
+				return "", "", err
+			}
+			if err := r.createPVC(ctx, md, pvc); err != nil {
+				return "", "", err
+			}
+			old.Status.Phase = corev1.ClaimPending
+		} else if !pvcManaged(md, old) {
+			return fmt.Sprintf(myAppsv1.ConditionMessageStorageOwnedFmt, pvc.Name, md.Name), myAppsv1.ConditionReasonStorageConflict, nil
+		} else if err := r.updatePVC(ctx, md, pvc, old); err != nil {
+			return "", "", err
+		}
+		if old.Status.Phase != corev1.ClaimBound && message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageStorageNotFmt, pvc.Name, old.Status.Phase)
+		}
+	}
+	return message, reason, nil
+}
+
+func (r *ZwhDeploymentReconciler) createPVC(ctx context.Context, md *myAppsv1.ZwhDeployment, pvc *corev1.PersistentVolumeClaim) error {
+	// reclaimPolicy 为 delete 时, pvc 所属于 md, 随 md 一起被垃圾回收
+	if pvcReclaimDelete(md) {
+		if err := controllerutil.SetControllerReference(md, pvc, r.Scheme); err != nil {
+			return err
+		}
+	}
+	return r.Client.Create(ctx, pvc)
+}
+
+// updatePVC pvc 创建后大部分字段不能修改, 只处理扩容和 reclaimPolicy 的变化
+// 只处理由 operator 创建的 pvc, 去掉 controller 引用之前先补上注解, 保留后仍然能判断归属
+func (r *ZwhDeploymentReconciler) updatePVC(ctx context.Context, md *myAppsv1.ZwhDeployment, pvc, old *corev1.PersistentVolumeClaim) error {
+	changed := false
+	if old.Annotations[myAppsv1.AnnotationStorageOwner] != md.Name {
+		metav1.SetMetaDataAnnotation(&old.ObjectMeta, myAppsv1.AnnotationStorageOwner, md.Name)
+		changed = true
+	}
+	size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size.Cmp(old.Spec.Resources.Requests[corev1.ResourceStorage]) > 0 {
+		if old.Spec.Resources.Requests == nil {
+			old.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		old.Spec.Resources.Requests[corev1.ResourceStorage] = size
+		changed = true
+	}
+	owned := metav1.IsControlledBy(old, md)
+	if pvcReclaimDelete(md) && !owned {
+		if err := controllerutil.SetControllerReference(md, old, r.Scheme); err != nil {
+			return err
+		}
+		changed = true
+	} else if !pvcReclaimDelete(md) && owned {
+		var refs []metav1.OwnerReference
+		for _, ref := range old.OwnerReferences {
+			if ref.UID != md.UID {
+				refs = append(refs, ref)
+			}
+		}
+		old.OwnerReferences = refs
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return r.Client.Update(ctx, old)
+}
+
+// reconcileCanary 处理金丝雀发布, stable 为稳定版本的 deployment
+// 镜像变化时创建 canary 的 deployment、service 和 ingress, 按照步骤调整流量, 全部完成后推广, 失败时中止
+func (r *ZwhDeploymentReconciler) reconcileCanary(ctx context.Context, md *myAppsv1.ZwhDeployment, stable *appsv1.Deployment) error {
+	if !canaryEnabled(md) {
+		return r.stopCanary(ctx, md)
+	}
+	now := metav1.Now()
+	stableImage := deployedImage(md, stable)
+	start := func(stableImage string) {
+		md.Status.Canary = &myAppsv1.CanaryStatus{
+			Phase:         myAppsv1.CanaryPhaseProgressing,
+			StableImage:   stableImage,
+			CanaryImage:   md.Spec.Image,
+			StepStartTime: &now,
+		}
+	}
+	// 1. 根据镜像的变化决定是否开始新的发布
+	status := md.Status.Canary
+	switch {
+	case status == nil || status.Phase == myAppsv1.CanaryPhasePromoted:
+		if md.Spec.Image == stableImage {
+			return nil
+		}
+		start(stableImage)
+	case status.Phase == myAppsv1.CanaryPhaseAborted:
+		// 中止后保持稳定版本, 直到镜像再次变化
+		if md.Spec.Image == status.CanaryImage {
+			return nil
+		}
+		if md.Spec.Image == status.StableImage {
+			md.Status.Canary = nil
+			return nil
+		}
+		start(status.StableImage)
+	case status.Phase == myAppsv1.CanaryPhasePromoting:
+		// 稳定版本滚动更新完成后删除 canary
+		if stableImage != status.CanaryImage || !deploymentRolledOut(stable) {
+			return nil
+		}
+		status.Phase = myAppsv1.CanaryPhasePromoted
+		status.StableImage = status.CanaryImage
+		return r.deleteCanary(ctx, md)
+	default:
+		// 镜像改回稳定版本时直接结束发布
+		if md.Spec.Image == status.StableImage {
+			return r.stopCanary(ctx, md)
+		}
+		// 发布过程中镜像再次变化, 从第一步重新开始
+		if md.Spec.Image != status.CanaryImage {
+			start(status.StableImage)
+		}
+	}
+	status = md.Status.Canary
+
+	// 2. 创建或更新 canary 的 deployment 和 service
+	canary, err := r.applyCanaryDeployment(ctx, md)
+	if err != nil {
+		return err
+	}
+	if err := r.applyCanaryService(ctx, md); err != nil {
+		return err
+	}
+
+	// 3. 根据 canary 的状态和当前步骤决定下一步
+	promote := md.Annotations[myAppsv1.AnnotationCanaryPromote] != ""
+	abort := md.Annotations[myAppsv1.AnnotationCanaryAbort] != ""
+	action, message := nextCanaryAction(md, canary, promote, abort, now.Time)
+	if action != canaryWait && action != canaryPause && (promote || abort) {
+		if err := r.removeAnnotations(ctx, md, myAppsv1.AnnotationCanaryPromote, myAppsv1.AnnotationCanaryAbort); err != nil {
+			return err
+		}
+	}
+	switch action {
+	case canaryAbort:
+		status.Phase = myAppsv1.CanaryPhaseAborted
+		status.Message = message
+		return r.deleteCanary(ctx, md)
+	case canaryPromote:
+		status.Phase = myAppsv1.CanaryPhasePromoting
+	case canaryAdvance:
+		status.Phase = myAppsv1.CanaryPhaseProgressing
+		status.CurrentStep++
+		status.StepStartTime = &now
+	case canaryPause:
+		status.Phase = myAppsv1.CanaryPhasePaused
+	default:
+		status.Phase = myAppsv1.CanaryPhaseProgressing
+	}
+
+	// 4. 按照当前步骤的权重把流量转发到 canary
+	return r.applyCanaryIngress(ctx, md)
+}
+
+// stopCanary 不再使用金丝雀发布时删除 canary 的资源和状态
+func (r *ZwhDeploymentReconciler) stopCanary(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	if md.Status.Canary == nil {
+		return nil
+	}
+	md.Status.Canary = nil
+	return r.deleteCanary(ctx, md)
+}
+
+// applyCanaryDeployment 创建或更新 canary deployment, 返回线上的对象
+func (r *ZwhDeploymentReconciler) applyCanaryDeployment(ctx context.Context, md *myAppsv1.ZwhDeployment) (*appsv1.Deployment, error) {
+	deploy, err := NewCanaryDeployment(md)
+	if err != nil {
+		return nil, err
+	}
+	if err := controllerutil.SetControllerReference(md, deploy, r.Scheme); err != nil {
+		return nil, err
+	}
+	old := new(appsv1.Deployment)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(deploy), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return deploy, r.Client.Create(ctx, deploy)
+	}
+	//预更新deployment。得到更新后的数据
+	if err := r.Update(ctx, deploy, client.DryRunAll); err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(old.Spec, deploy.Spec) {
+		return old, nil
+	}
+	return deploy, r.Client.Update(ctx, deploy)
+}
+
+func (r *ZwhDeploymentReconciler) applyCanaryService(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	svc, err := NewCanaryService(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, svc, r.Scheme); err != nil {
+		return err
+	}
+	old := new(corev1.Service)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return r.Client.Create(ctx, svc)
+	}
+	//预更新service。得到更新后的数据
+	if err := r.Update(ctx, svc, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, svc.Spec) && reflect.DeepEqual(old.Annotations, svc.Annotations) {
+		return nil
+	}
+	return r.Client.Update(ctx, svc)
+}
+
+// applyCanaryIngress 创建或更新 canary ingress, 权重为当前步骤的 weight
+func (r *ZwhDeploymentReconciler) applyCanaryIngress(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	ig, err := NewCanaryIngress(md, canaryWeight(md))
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, ig, r.Scheme); err != nil {
+		return err
+	}
+	old := new(networkv1.Ingress)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(ig), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return r.Client.Create(ctx, ig)
+	}
+	//预更新ingress。得到更新后的数据
+	if err := r.Update(ctx, ig, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, ig.Spec) && reflect.DeepEqual(old.Annotations, ig.Annotations) {
+		return nil
+	}
+	return r.Client.Update(ctx, ig)
+}
+
+// deleteCanary 删除 canary 的资源, 先删除 ingress 把流量切回稳定版本
+func (r *ZwhDeploymentReconciler) deleteCanary(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	meta := metav1.ObjectMeta{Name: canaryName(md)}
+	for _, obj := range []client.Object{
+		&networkv1.Ingress{ObjectMeta: meta},
+		&appsv1.Deployment{ObjectMeta: meta},
+		&corev1.Service{ObjectMeta: meta},
+	} {
+		if err := r.deleteOwned(ctx, md, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileBlueGreen 处理蓝绿发布, active 为稳定版本的 deployment
+// 镜像变化时完整运行一个 preview deployment, 推广时先把主 service 切换到 preview,
+// scaleDownDelay 之后稳定版本滚动更新到新镜像, 完成后 service 切回稳定版本并删除 preview
+func (r *ZwhDeploymentReconciler) reconcileBlueGreen(ctx context.Context, md *myAppsv1.ZwhDeployment, active *appsv1.Deployment) error {
+	if !blueGreenEnabled(md) {
+		return r.stopBlueGreen(ctx, md)
+	}
+	now := metav1.Now()
+	activeImage := deployedImage(md, active)
+	start := func(activeColor, activeImage string) {
+		md.Status.BlueGreen = &myAppsv1.BlueGreenStatus{
+			Phase:        myAppsv1.BlueGreenPhasePreviewing,
+			ActiveColor:  activeColor,
+			PreviewColor: otherColor(activeColor),
+			ActiveImage:  activeImage,
+			PreviewImage: md.Spec.Image,
+		}
+	}
+	// 1. 根据镜像的变化决定是否开始新的发布
+	status := md.Status.BlueGreen
+	switch {
+	case status == nil || status.Phase == myAppsv1.BlueGreenPhasePromoted || status.Phase == myAppsv1.BlueGreenPhaseAborted:
+		// 上一次发布结束时 service 已经切回稳定版本, 这时再删除 preview
+		if err := r.cleanupPreview(ctx, md); err != nil {
+			return err
+		}
+		switch {
+		case status == nil:
+			if md.Spec.Image == activeImage {
+				return nil
+			}
+			start(myAppsv1.BlueGreenColorBlue, activeImage)
+		case status.Phase == myAppsv1.BlueGreenPhaseAborted:
+			// 中止后保持稳定版本, 直到镜像再次变化
+			if md.Spec.Image == status.PreviewImage {
+				return nil
+			}
+			if md.Spec.Image == status.ActiveImage {
+				md.Status.BlueGreen = nil
+				return nil
+			}
+			start(status.ActiveColor, status.ActiveImage)
+		default:
+			if md.Spec.Image == activeImage {
+				return nil
+			}
+			start(status.ActiveColor, activeImage)
+		}
+	case status.Phase == myAppsv1.BlueGreenPhasePromoting:
+		// 稳定版本滚动更新完成后推广, 下一次 reconcile 时 service 已经切回稳定版本, 再删除 preview
+		if activeImage != status.PreviewImage || !deploymentRolledOut(active) {
+			return nil
+		}
+		status.Phase = myAppsv1.BlueGreenPhasePromoted
+		status.ActiveImage = status.PreviewImage
+		status.ActiveColor = status.PreviewColor
+		status.PreviewColor = ""
+		status.SwitchTime = nil
+		return nil
+	case md.Spec.Image == status.ActiveImage:
+		// 镜像改回稳定版本时中止发布
+		status.Phase = myAppsv1.BlueGreenPhaseAborted
+		status.Message = fmt.Sprintf("image is reverted to %s", status.ActiveImage)
+		status.PreviewColor = ""
+		return nil
+	case md.Spec.Image != status.PreviewImage && status.Phase != myAppsv1.BlueGreenPhaseSwitched:
+		// 切换流量之前镜像再次变化, 重新运行 preview; 切换之后等推广完成再开始新的发布
+		start(status.ActiveColor, status.ActiveImage)
+	}
+	status = md.Status.BlueGreen
+
+	// 2. 创建或更新 preview 的 deployment、service 和 ingress
+	preview, err := r.applyPreviewDeployment(ctx, md, active)
+	if err != nil {
+		return err
+	}
+	if err := r.applyPreviewService(ctx, md); err != nil {
+		return err
+	}
+	if err := r.applyPreviewIngress(ctx, md); err != nil {
+		return err
+	}
+
+	// 3. 根据 preview 的状态和当前阶段决定下一步
+	promote := md.Annotations[myAppsv1.AnnotationBlueGreenPromote] != ""
+	abort := md.Annotations[myAppsv1.AnnotationBlueGreenAbort] != ""
+	action, message := nextBlueGreenAction(md, preview, promote, abort, now.Time)
+	if action != blueGreenWait && action != blueGreenPause && (promote || abort) {
+		if err := r.removeAnnotations(ctx, md, myAppsv1.AnnotationBlueGreenPromote, myAppsv1.AnnotationBlueGreenAbort); err != nil {
+			return err
+		}
+	}
+	switch action {
+	case blueGreenAbort:
+		// 流量切回稳定版本之后再删除 preview
+		status.Phase = myAppsv1.BlueGreenPhaseAborted
+		status.Message = message
+		status.PreviewColor = ""
+		status.SwitchTime = nil
+	case blueGreenSwitch:
+		status.Phase = myAppsv1.BlueGreenPhaseSwitched
+		status.SwitchTime = &now
+	case blueGreenPromote:
+		status.Phase = myAppsv1.BlueGreenPhasePromoting
+	case blueGreenPause:
+		status.Phase = myAppsv1.BlueGreenPhasePaused
+	default:
+		if status.Phase != myAppsv1.BlueGreenPhaseSwitched {
+			status.Phase = myAppsv1.BlueGreenPhasePreviewing
+		}
+	}
+	return nil
+}
+
+// stopBlueGreen 不再使用蓝绿发布时删除 preview 的资源和状态
+func (r *ZwhDeploymentReconciler) stopBlueGreen(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	if md.Status.BlueGreen == nil {
+		return nil
+	}
+	md.Status.BlueGreen = nil
+	return r.deletePreview(ctx, md)
+}
+
+// cleanupPreview 发布结束后删除还存在的 preview
+func (r *ZwhDeploymentReconciler) cleanupPreview(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	if md.Status.BlueGreen == nil {
+		return nil
+	}
+	deploy := new(appsv1.Deployment)
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: previewName(md), Namespace: md.Namespace}, deploy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return r.deletePreview(ctx, md)
+}
+
+// applyPreviewDeployment 创建或更新 preview deployment, 返回线上的对象
+func (r *ZwhDeploymentReconciler) applyPreviewDeployment(ctx context.Context, md *myAppsv1.ZwhDeployment, active *appsv1.Deployment) (*appsv1.Deployment, error) {
+	deploy, err := NewPreviewDeployment(md)
+	if err != nil {
+		return nil, err
+	}
+	if err := controllerutil.SetControllerReference(md, deploy, r.Scheme); err != nil {
+		return nil, err
+	}
+	// 开启自动扩缩容时, 和稳定版本保持相同的副本数
+	if autoscalingEnabled(md) {
+		deploy.Spec.Replicas = active.Spec.Replicas
+	}
+	old := new(appsv1.Deployment)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(deploy), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return deploy, r.Client.Create(ctx, deploy)
+	}
+	//预更新deployment。得到更新后的数据
+	if err := r.Update(ctx, deploy, client.DryRunAll); err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(old.Spec, deploy.Spec) {
+		return old, nil
+	}
+	return deploy, r.Client.Update(ctx, deploy)
+}
+
+func (r *ZwhDeploymentReconciler) applyPreviewService(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	svc, err := NewPreviewService(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, svc, r.Scheme); err != nil {
+		return err
+	}
+	old := new(corev1.Service)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return r.Client.Create(ctx, svc)
+	}
+	//预更新service。得到更新后的数据
+	if err := r.Update(ctx, svc, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, svc.Spec) {
+		return nil
+	}
+	return r.Client.Update(ctx, svc)
+}
+
+// applyPreviewIngress 填写了 previewHost 时创建或更新 preview ingress, 否则删除
+func (r *ZwhDeploymentReconciler) applyPreviewIngress(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	if md.Spec.Strategy.BlueGreen.PreviewHost == "" {
+		return r.deleteOwned(ctx, md, &networkv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: previewName(md)}})
+	}
+	ig, err := NewPreviewIngress(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, ig, r.Scheme); err != nil {
+		return err
+	}
+	old := new(networkv1.Ingress)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(ig), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return r.Client.Create(ctx, ig)
+	}
+	//预更新ingress。得到更新后的数据
+	if err := r.Update(ctx, ig, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, ig.Spec) && reflect.DeepEqual(old.Annotations, ig.Annotations) {
+		return nil
+	}
+	return r.Client.Update(ctx, ig)
+}
+
+// deletePreview 删除 preview 的资源
+func (r *ZwhDeploymentReconciler) deletePreview(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	meta := metav1.ObjectMeta{Name: previewName(md)}
+	for _, obj := range []client.Object{
+		&networkv1.Ingress{ObjectMeta: meta},
+		&appsv1.Deployment{ObjectMeta: meta},
+		&corev1.Service{ObjectMeta: meta},
+	} {
+		if err := r.deleteOwned(ctx, md, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAnnotations 删除已经处理的注解
+// 同步 resourceVersion, 避免后面更新 status 时冲突
+func (r *ZwhDeploymentReconciler) removeAnnotations(ctx context.Context, md *myAppsv1.ZwhDeployment, keys ...string) error {
+	annotations := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		annotations[key] = nil
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	obj := md.DeepCopy()
+	if err := r.Client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, data)); err != nil {
+		return err
+	}
+	md.ResourceVersion = obj.ResourceVersion
+	for _, key := range keys {
+		delete(md.Annotations, key)
+	}
+	return nil
+}
+
+// reconcileRevisions 把当前的 spec 和 pod 模板的镜像保存为最新的 ControllerRevision, 并删除超过 revisionHistoryLimit 的旧版本
+func (r *ZwhDeploymentReconciler) reconcileRevisions(ctx context.Context, md *myAppsv1.ZwhDeployment, template *corev1.PodTemplateSpec) error {
+	revisions, err := r.listRevisions(ctx, md)
+	if err != nil {
+		return err
+	}
+	rev, err := NewControllerRevision(md, template, 0)
+	if err != nil {
+		return err
+	}
+	var latest int64
+	var current *appsv1.ControllerRevision
+	for i := range revisions {
+		if revisions[i].Revision > latest {
+			latest = revisions[i].Revision
+		}
+		if revisions[i].Name == rev.Name {
+			current = &revisions[i]
+		}
+	}
+	appliedAt := time.Now().UTC().Format(time.RFC3339)
+	switch {
+	case current == nil:
+		rev.Revision = latest + 1
+		rev.Annotations = map[string]string{revisionAppliedAnnotation: appliedAt}
+		if err := controllerutil.SetControllerReference(md, rev, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Client.Create(ctx, rev); err != nil {
+			return err
+		}
+		revisions = append(revisions, *rev)
+		current = rev
+	case current.Revision != latest:
+		// 回滚到之前的 spec 时, 已有的版本成为最新的版本
+		current.Revision = latest + 1
+		if current.Annotations == nil {
+			current.Annotations = make(map[string]string, 1)
+		}
+		current.Annotations[revisionAppliedAnnotation] = appliedAt
+		if err := r.Client.Update(ctx, current); err != nil {
+			return err
+		}
+	}
+	md.Status.CurrentRevision = current.Revision
+	for _, old := range revisionsToPrune(revisions, revisionHistoryLimit(md), current.Name) {
+		if err := r.Client.Delete(ctx, &old); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listRevisions 获取属于 md 的所有 ControllerRevision
+func (r *ZwhDeploymentReconciler) listRevisions(ctx context.Context, md *myAppsv1.ZwhDeployment) ([]appsv1.ControllerRevision, error) {
+	list := new(appsv1.ControllerRevisionList)
+	if err := r.Client.List(ctx, list, client.InNamespace(md.Namespace), client.MatchingLabels{revisionLabel: md.Name}); err != nil {
+		return nil, err
+	}
+	revisions := make([]appsv1.ControllerRevision, 0, len(list.Items))
+	for _, rev := range list.Items {
+		if metav1.IsControlledBy(&rev, md) {
+			revisions = append(revisions, rev)
+		}
+	}
+	return revisions, nil
+}
+
+// rollbackToRevision 把 md 的 spec 改为指定版本中保存的 spec, 同时删除回滚注解
+// 版本中固定了镜像摘要时写回 status, 避免重新解析 tag 得到不同的镜像
+// 版本不存在时记录事件并删除注解
+func (r *ZwhDeploymentReconciler) rollbackToRevision(ctx context.Context, md *myAppsv1.ZwhDeployment, value string) error {
+	revisions, err := r.listRevisions(ctx, md)
+	if err != nil {
+		return err
+	}
+	if revision, err := strconv.ParseInt(value, 10, 64); err == nil {
+		for i := range revisions {
+			if revisions[i].Revision != revision {
+				continue
+			}
+			data, err := readRevision(&revisions[i])
+			if err != nil {
+				return err
+			}
+			md.Spec = data.Spec
+			delete(md.Annotations, myAppsv1.AnnotationRollbackTo)
+			if err := r.Client.Update(ctx, md); err != nil {
+				return err
+			}
+			if digest := data.imageDigest(); digest != "" && md.Spec.ImagePolicy == myAppsv1.ImagePolicyPinDigest {
+				recordImageDigest(md, digest, metav1.Now())
+				if err := r.Client.Status().Update(ctx, md); err != nil {
+					return err
+				}
+			}
+			r.Recorder.Eventf(md, corev1.EventTypeNormal, "RevisionRollback", "Rolled back to revision %d", revision)
+			return nil
+		}
+	}
+	r.Recorder.Eventf(md, corev1.EventTypeWarning, "RevisionNotFound", "Revision %s is not found", value)
+	return r.removeAnnotations(ctx, md, myAppsv1.AnnotationRollbackTo)
+}
+
+// reconcileConfig 创建或更新 operator 生成的 configmap, 计算 pod 使用的所有配置的哈希
+// 返回第一个不存在的引用的信息, 都存在时返回空字符串
+func (r *ZwhDeploymentReconciler) reconcileConfig(ctx context.Context, md *myAppsv1.ZwhDeployment) (string, error) {
+	var configMaps []*corev1.ConfigMap
+	var secrets []*corev1.Secret
+	if cm := NewConfigMap(md); cm != nil {
+		old := new(corev1.ConfigMap)
+		if err := r.APIReader.Get(ctx, client.ObjectKeyFromObject(cm), old); err != nil {
+			if !errors.IsNotFound(err) {
+				return "", err
+			}
+			if err := r.createConfigMap(ctx, md, cm); err != nil {
+				return "", err
+			}
+		} else if err := r.updateConfigMap(ctx, md, cm, old); err != nil {
+			return "", err
+		}
+		configMaps = append(configMaps, cm)
+	} else if err := r.deleteConfigMap(ctx, md); err != nil {
+		return "", err
+	}
+
+	message := ""
+	for _, ref := range referencedConfig(md) {
+		key := types.NamespacedName{Namespace: md.Namespace, Name: ref.name}
+		var err error
+		if ref.kind == "Secret" {
+			secret := new(corev1.Secret)
+			if err = r.APIReader.Get(ctx, key, secret); err == nil {
+				secrets = append(secrets, secret)
+			}
+		} else {
+			cm := new(corev1.ConfigMap)
+			if err = r.APIReader.Get(ctx, key, cm); err == nil {
+				configMaps = append(configMaps, cm)
+			}
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return "", err
+		}
+		// 不存在时 pod 无法启动, optional 的引用除外
+		if err != nil && !ref.optional && message == "" {
+			message = fmt.Sprintf(myAppsv1.ConditionMessageConfigNotFmt, ref.kind, ref.name, md.Name)
+		}
+	}
+	md.Status.ConfigHash = configHash(configMaps, secrets)
+	return message, nil
+}
+
+func (r *ZwhDeploymentReconciler) createConfigMap(ctx context.Context, md *myAppsv1.ZwhDeployment, cm *corev1.ConfigMap) error {
+	if err := controllerutil.SetControllerReference(md, cm, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, cm)
+}
+
+func (r *ZwhDeploymentReconciler) updateConfigMap(ctx context.Context, md *myAppsv1.ZwhDeployment, cm, old *corev1.ConfigMap) error {
+	if reflect.DeepEqual(cm.Data, old.Data) && metav1.IsControlledBy(old, md) {
+		return nil
+	}
+	if err := controllerutil.SetControllerReference(md, cm, r.Scheme); err != nil {
+		return err
+	}
+	cm.ResourceVersion = old.ResourceVersion
+	return r.Client.Update(ctx, cm)
+}
+
+func (r *ZwhDeploymentReconciler) deleteConfigMap(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	cm := new(corev1.ConfigMap)
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: md.Namespace, Name: configMapName(md)}, cm); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	// 同名的 configmap 不是 operator 创建的时候不能删除
+	if !metav1.IsControlledBy(cm, md) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Client.Delete(ctx, cm))
+}
+
+// reconcileServiceAccount 创建或更新 operator 管理的 ServiceAccount、Role 和 RoleBinding
+// 引用的 ServiceAccount 不存在, 或者同名的对象不是 operator 创建的时候返回 condition 的 message 和 reason
+func (r *ZwhDeploymentReconciler) reconcileServiceAccount(ctx context.Context, md *myAppsv1.ZwhDeployment) (string, string, error) {
+	// 1. 处理 ServiceAccount
+	message, reason := "", myAppsv1.ConditionReasonSANotFound
+	if sa := NewServiceAccount(md); sa != nil {
+		owned, err := r.applyServiceAccount(ctx, md, sa)
+		if err != nil {
+			return "", "", err
+		}
+		if !owned {
+			return fmt.Sprintf(myAppsv1.ConditionMessageSAOwnedFmt, "ServiceAccount", sa.Name, md.Name), myAppsv1.ConditionReasonSAConflict, nil
+		}
+	} else {
+		if err := r.deleteOwned(ctx, md, &corev1.ServiceAccount{}); err != nil {
+			return "", "", err
+		}
+		if name := serviceAccountName(md); name != "" {
+			key := types.NamespacedName{Namespace: md.Namespace, Name: name}
+			if err := r.Client.Get(ctx, key, new(corev1.ServiceAccount)); err != nil {
+				if !errors.IsNotFound(err) {
+					return "", "", err
+				}
+				message = fmt.Sprintf(myAppsv1.ConditionMessageSANotFmt, name, md.Name)
+			}
+		}
+	}
+
+	// 2. 处理 Role 和 RoleBinding, 先删除 RoleBinding 再删除 Role
+	role, binding := NewRole(md), NewRoleBinding(md)
+	if role == nil {
+		if err := r.deleteOwned(ctx, md, &rbacv1.RoleBinding{}); err != nil {
+			return "", "", err
+		}
+		return message, reason, r.deleteOwned(ctx, md, &rbacv1.Role{})
+	}
+	owned, err := r.applyRole(ctx, md, role)
+	if err != nil {
+		return "", "", err
 	}
-	logger.Info("reconcile is ended")
-	return ctrl.Result{}, nil
+	if !owned {
+		return fmt.Sprintf(myAppsv1.ConditionMessageSAOwnedFmt, "Role", role.Name, md.Name), myAppsv1.ConditionReasonSAConflict, nil
+	}
+	if owned, err = r.applyRoleBinding(ctx, md, binding); err != nil {
+		return "", "", err
+	}
+	if !owned {
+		return fmt.Sprintf(myAppsv1.ConditionMessageSAOwnedFmt, "RoleBinding", binding.Name, md.Name), myAppsv1.ConditionReasonSAConflict, nil
+	}
+	return message, reason, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *ZwhDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&myAppsv1.ZwhDeployment{}).
-		Owns(&appsv1.Deployment{}). //监控deployment类型，变更就触发reconciler
-		Owns(&corev1.Service{}).    //监控service类型，变更就触发reconciler
-		Owns(&networkv1.Ingress{}). //监控ingress类型，变更就触发reconciler
-		Complete(r)
+// applyServiceAccount 创建或更新 ServiceAccount, 同名的 ServiceAccount 不是 operator 创建的时候不修改它, 返回 false
+func (r *ZwhDeploymentReconciler) applyServiceAccount(ctx context.Context, md *myAppsv1.ZwhDeployment, sa *corev1.ServiceAccount) (bool, error) {
+	old := new(corev1.ServiceAccount)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(sa), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return true, err
+		}
+		if err := controllerutil.SetControllerReference(md, sa, r.Scheme); err != nil {
+			return true, err
+		}
+		return true, r.Client.Create(ctx, sa)
+	}
+	// 不能接管已有的 ServiceAccount, 否则删除 md 时它会被垃圾回收
+	if !metav1.IsControlledBy(old, md) {
+		return false, nil
+	}
+	if reflect.DeepEqual(old.AutomountServiceAccountToken, sa.AutomountServiceAccountToken) {
+		return true, nil
+	}
+	// secrets 和 imagePullSecrets 可能由其他控制器维护, 只修改 operator 管理的字段
+	old.AutomountServiceAccountToken = sa.AutomountServiceAccountToken
+	return true, r.Client.Update(ctx, old)
 }
 
-func (r *ZwhDeploymentReconciler) createDeployment(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
-	deploy, err := NewDeployment(md)
-	if err != nil {
-		return err
+// applyRole 创建或更新 Role, 同名的 Role 不是 operator 创建的时候不修改它, 返回 false
+func (r *ZwhDeploymentReconciler) applyRole(ctx context.Context, md *myAppsv1.ZwhDeployment, role *rbacv1.Role) (bool, error) {
+	if err := controllerutil.SetControllerReference(md, role, r.Scheme); err != nil {
+		return true, err
+	}
+	old := new(rbacv1.Role)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(role), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return true, err
+		}
+		return true, r.Client.Create(ctx, role)
 	}
+	if !metav1.IsControlledBy(old, md) {
+		return false, nil
+	}
+	if reflect.DeepEqual(old.Rules, role.Rules) {
+		return true, nil
+	}
+	role.ResourceVersion = old.ResourceVersion
+	return true, r.Client.Update(ctx, role)
+}
 
-	//设置deployment所属于md
-	if err := controllerutil.SetControllerReference(md, deploy, r.Scheme); err != nil {
-		return err
+// applyRoleBinding 创建或更新 RoleBinding, 同名的 RoleBinding 不是 operator 创建的时候不修改它, 返回 false
+func (r *ZwhDeploymentReconciler) applyRoleBinding(ctx context.Context, md *myAppsv1.ZwhDeployment, binding *rbacv1.RoleBinding) (bool, error) {
+	if err := controllerutil.SetControllerReference(md, binding, r.Scheme); err != nil {
+		return true, err
+	}
+	old := new(rbacv1.RoleBinding)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(binding), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return true, err
+		}
+		return true, r.Client.Create(ctx, binding)
 	}
-	return r.Client.Create(ctx, deploy, client.DryRunAll)
+	if !metav1.IsControlledBy(old, md) {
+		return false, nil
+	}
+	if reflect.DeepEqual(old.RoleRef, binding.RoleRef) && reflect.DeepEqual(old.Subjects, binding.Subjects) {
+		return true, nil
+	}
+	// roleRef 不能修改, 不一致时删除后重新创建
+	if !reflect.DeepEqual(old.RoleRef, binding.RoleRef) {
+		if err := r.Client.Delete(ctx, old); client.IgnoreNotFound(err) != nil {
+			return true, err
+		}
+		return true, r.Client.Create(ctx, binding)
+	}
+	binding.ResourceVersion = old.ResourceVersion
+	return true, r.Client.Update(ctx, binding)
+}
 
+// applyNetworkPolicy 创建或更新 networkpolicy
+// 同名的 networkpolicy 不是 operator 创建的时候不覆盖, 返回 condition 的 message 和 reason
+func (r *ZwhDeploymentReconciler) applyNetworkPolicy(ctx context.Context, md *myAppsv1.ZwhDeployment, np *networkv1.NetworkPolicy) (string, string, error) {
+	if err := controllerutil.SetControllerReference(md, np, r.Scheme); err != nil {
+		return "", "", err
+	}
+	old := new(networkv1.NetworkPolicy)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(np), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", "", err
+		}
+		return "", "", r.Client.Create(ctx, np)
+	}
+	if !metav1.IsControlledBy(old, md) {
+		return fmt.Sprintf(myAppsv1.ConditionMessagePolicyOwnedFmt, np.Name, md.Name), myAppsv1.ConditionReasonPolicyConflict, nil
+	}
+	//预更新networkpolicy。得到更新后的数据
+	np.ResourceVersion = old.ResourceVersion
+	if err := r.Update(ctx, np, client.DryRunAll); err != nil {
+		return "", "", err
+	}
+	if reflect.DeepEqual(old.Spec, np.Spec) {
+		return "", "", nil
+	}
+	return "", "", r.Client.Update(ctx, np)
 }
 
-func (r *ZwhDeploymentReconciler) updateDeployment(ctx context.Context, md *myAppsv1.ZwhDeployment, dp *appsv1.Deployment) error {
-	deploy, err := NewDeployment(md)
+// reconcileRegistryCredentials 把集中管理的镜像仓库凭证复制到当前 namespace, 来源变化时同步
+// 来源不存在或者类型不对时返回 condition 的 message 和 reason, 已经复制的凭证保持不变
+// 只能引用 operator 配置的集中 namespace 中的 secret, 避免读取其他 namespace 中的凭证
+func (r *ZwhDeploymentReconciler) reconcileRegistryCredentials(ctx context.Context, md *myAppsv1.ZwhDeployment) (string, string, error) {
+	ref := md.Spec.RegistryCredentials
+	if r.RegistryNamespace == "" {
+		return fmt.Sprintf(myAppsv1.ConditionMessageRegistryOffFmt, ref.Name, md.Name), myAppsv1.ConditionReasonRegistryDisabled, nil
+	}
+	key := types.NamespacedName{Namespace: r.RegistryNamespace, Name: ref.Name}
+	source := new(corev1.Secret)
+	if err := r.registryCache.Get(ctx, key, source); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", "", err
+		}
+		return fmt.Sprintf(myAppsv1.ConditionMessageRegistryNotFmt, key, md.Name), myAppsv1.ConditionReasonRegistryNotFound, nil
+	}
+	if !registryCredentialsValid(source) {
+		return fmt.Sprintf(myAppsv1.ConditionMessageRegistryTypeFmt, key, source.Type,
+			corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg), myAppsv1.ConditionReasonRegistryInvalid, nil
+	}
+
+	secret := NewRegistrySecret(md, source)
+	if err := controllerutil.SetControllerReference(md, secret, r.Scheme); err != nil {
+		return "", "", err
+	}
+	old := new(corev1.Secret)
+	if err := r.APIReader.Get(ctx, client.ObjectKeyFromObject(secret), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", "", err
+		}
+		return "", "", r.Client.Create(ctx, secret)
+	}
+	// 同名的 secret 不是 operator 创建的时候不能覆盖或删除
+	if !metav1.IsControlledBy(old, md) {
+		return fmt.Sprintf(myAppsv1.ConditionMessageRegistryOwnedFmt, secret.Name, md.Name), myAppsv1.ConditionReasonRegistryConflict, nil
+	}
+	if old.Type == secret.Type && reflect.DeepEqual(old.Data, secret.Data) &&
+		reflect.DeepEqual(old.Annotations, secret.Annotations) {
+		return "", "", nil
+	}
+	// secret 的类型不能修改, 不一致时删除后重新创建
+	if old.Type != secret.Type {
+		if err := r.Client.Delete(ctx, old); client.IgnoreNotFound(err) != nil {
+			return "", "", err
+		}
+		return "", "", r.Client.Create(ctx, secret)
+	}
+	secret.ResourceVersion = old.ResourceVersion
+	return "", "", r.Client.Update(ctx, secret)
+}
 
+// resolveImage 获取 spec.image 的摘要, 同一个镜像只解析一次
+// 使用 pod 的 imagePullSecrets 访问私有镜像仓库, 不存在的 secret 忽略
+func (r *ZwhDeploymentReconciler) resolveImage(ctx context.Context, md *myAppsv1.ZwhDeployment) (string, error) {
+	if digest, ok := imageDigest(md); ok {
+		return digest, nil
+	}
+	var secrets []*corev1.Secret
+	for _, ref := range imagePullSecrets(md) {
+		secret := new(corev1.Secret)
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: md.Namespace, Name: ref.Name}, secret); err != nil {
+			if !errors.IsNotFound(err) {
+				return "", err
+			}
+			continue
+		}
+		secrets = append(secrets, secret)
+	}
+	registry := r.Registry
+	if registry == nil {
+		registry = new(RegistryClient)
+	}
+	digest, err := registry.Resolve(ctx, md.Spec.Image, registryAuths(secrets))
 	if err != nil {
-		return err
+		return "", err
 	}
-	// 设置 deployment 所属于 md
-	if err := controllerutil.SetControllerReference(md, deploy, r.Scheme); err != nil {
-		return err
+	recordImageDigest(md, digest, metav1.Now())
+	return digest, nil
+}
+
+// findRegistryCredentials 集中管理的镜像仓库凭证不属于 md, 变化时使用 registryCredentialsIndex 索引找到引用它的 md 重新复制
+func (r *ZwhDeploymentReconciler) findRegistryCredentials(ctx context.Context, obj client.Object) []reconcile.Request {
+	list := new(myAppsv1.ZwhDeploymentList)
+	if err := r.Client.List(ctx, list, client.MatchingFields{registryCredentialsIndex: obj.GetName()}); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for i := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
 	}
+	return requests
+}
 
-	//预更新deployment。得到更新后的数据
-	if err := r.Update(ctx, deploy, client.DryRunAll); err != nil {
-		return err
+// deleteOwned 删除由 ZwhDeployment 创建的对象, obj 没有名称时使用 ZwhDeployment 的名称, 需要是幂等的
+// 同名的对象不是 operator 创建的时候不能删除
+func (r *ZwhDeploymentReconciler) deleteOwned(ctx context.Context, md *myAppsv1.ZwhDeployment, obj client.Object) error {
+	key := client.ObjectKeyFromObject(md)
+	if obj.GetName() != "" {
+		key.Name = obj.GetName()
 	}
-	if reflect.DeepEqual(dp.Spec, deploy.Spec) {
+	if err := r.Client.Get(ctx, key, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, md) {
 		return nil
 	}
-	return r.Client.Update(ctx, deploy)
+	return client.IgnoreNotFound(r.Client.Delete(ctx, obj))
+}
+
+// findReferencing 返回一个 MapFunc, 找到引用了这个 configmap 或 secret 的 md
+func (r *ZwhDeploymentReconciler) findReferencing(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		list, err := r.listReferencing(ctx, kind, obj)
+		if err != nil {
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(list.Items))
+		for i := range list.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+		}
+		return requests
+	}
+}
+
+// referencedPredicate 只处理同一个 namespace 中有 md 引用的 configmap 或 secret, 其他 namespace 的变化直接忽略
+func (r *ZwhDeploymentReconciler) referencedPredicate(kind string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		list, err := r.listReferencing(context.Background(), kind, obj)
+		return err == nil && len(list.Items) > 0
+	})
+}
 
+// listReferencing 使用 configRefIndex 索引找到 obj 所在 namespace 中引用了它的 md
+func (r *ZwhDeploymentReconciler) listReferencing(ctx context.Context, kind string, obj client.Object) (*myAppsv1.ZwhDeploymentList, error) {
+	list := new(myAppsv1.ZwhDeploymentList)
+	err := r.Client.List(ctx, list,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{configRefIndex: kind + "/" + obj.GetName()})
+	return list, err
 }
 
 func (r *ZwhDeploymentReconciler) createService(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
@@ -396,7 +2362,7 @@ func (r *ZwhDeploymentReconciler) updateService(ctx context.Context, md *myAppsv
 	if err := r.Update(ctx, svc, client.DryRunAll); err != nil {
 		return err
 	}
-	if reflect.DeepEqual(service.Spec, svc.Spec) {
+	if reflect.DeepEqual(service.Spec, svc.Spec) && reflect.DeepEqual(service.Annotations, svc.Annotations) {
 		return nil
 	}
 	return r.Client.Update(ctx, svc)
@@ -415,7 +2381,37 @@ func (r *ZwhDeploymentReconciler) updateNPSerive(ctx context.Context, md *myApps
 	if err := r.Update(ctx, svc, client.DryRunAll); err != nil {
 		return err
 	}
-	if reflect.DeepEqual(service.Spec, svc.Spec) {
+	if reflect.DeepEqual(service.Spec, svc.Spec) && reflect.DeepEqual(service.Annotations, svc.Annotations) {
+		return nil
+	}
+	return r.Client.Update(ctx, svc)
+}
+
+func (r *ZwhDeploymentReconciler) createLBService(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	svc, err := NewServiceLB(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, svc, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, svc)
+}
+
+// updateLBService 负载均衡的地址池等配置在注解中, 注解变化时也需要更新
+func (r *ZwhDeploymentReconciler) updateLBService(ctx context.Context, md *myAppsv1.ZwhDeployment, service *corev1.Service) error {
+	svc, err := NewServiceLB(md)
+	if err != nil {
+		return err
+	}
+	if err := controllerutil.SetControllerReference(md, svc, r.Scheme); err != nil {
+		return err
+	}
+	//预更新service。得到更新后的数据
+	if err := r.Update(ctx, svc, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(service.Spec, svc.Spec) && reflect.DeepEqual(service.Annotations, svc.Annotations) {
 		return nil
 	}
 	return r.Client.Update(ctx, svc)
@@ -440,23 +2436,53 @@ func (r *ZwhDeploymentReconciler) updateIngress(ctx context.Context, md *myAppsv
 	if err := controllerutil.SetControllerReference(md, ig, r.Scheme); err != nil {
 		return err
 	}
-	//预更新service。得到更新后的数据
-	if err := r.Update(ctx, ingress, client.DryRunAll); err != nil {
+	//预更新ingress。得到更新后的数据
+	if err := r.Update(ctx, ig, client.DryRunAll); err != nil {
 		return err
 	}
-	if reflect.DeepEqual(ingress.Spec, ig.Spec) {
+	if reflect.DeepEqual(ingress.Spec, ig.Spec) && reflect.DeepEqual(ingress.Annotations, ig.Annotations) {
 		return nil
 	}
 	return r.Client.Update(ctx, ig)
 }
 
-func (r *ZwhDeploymentReconciler) deleteIngress(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
-
-	ig, err := NewIngress(md)
-	if err != nil {
+// applyRewriteIngress mode 为 ingress 并且部分规则需要重写路径时创建或更新单独的 ingress, 否则删除
+func (r *ZwhDeploymentReconciler) applyRewriteIngress(ctx context.Context, md *myAppsv1.ZwhDeployment, mode string) error {
+	var ig *networkv1.Ingress
+	if mode == myAppsv1.ModeIngress {
+		var err error
+		if ig, err = NewRewriteIngress(md); err != nil {
+			return err
+		}
+	}
+	if ig == nil {
+		return r.deleteOwned(ctx, md, &networkv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: rewriteIngressName(md)}})
+	}
+	if err := controllerutil.SetControllerReference(md, ig, r.Scheme); err != nil {
 		return err
 	}
-	return r.Client.Delete(ctx, ig)
+	old := new(networkv1.Ingress)
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(ig), old); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return r.Client.Create(ctx, ig)
+	}
+	if !metav1.IsControlledBy(old, md) {
+		return fmt.Errorf("ingress %s already exists and is not managed by %s", ig.Name, md.Name)
+	}
+	//预更新ingress。得到更新后的数据
+	if err := r.Update(ctx, ig, client.DryRunAll); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Spec, ig.Spec) && reflect.DeepEqual(old.Annotations, ig.Annotations) {
+		return nil
+	}
+	return r.Client.Update(ctx, ig)
+}
+
+func (r *ZwhDeploymentReconciler) deleteIngress(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	return r.deleteOwned(ctx, md, &networkv1.Ingress{})
 }
 
 // 更新Condition，并变更版本
@@ -570,13 +2596,13 @@ func (r *ZwhDeploymentReconciler) updateStatus(ctx context.Context, md *myAppsv1
 // 只是删除对应的Condition不做更多的操作
 func (r *ZwhDeploymentReconciler) deleteStatus(md *myAppsv1.ZwhDeployment, conditionType string) {
 	// 1. 遍历conditions
-	var tmp []myAppsv1.Condition
-	copy(tmp, md.Status.Conditions)
-	for i := range tmp {
+	for i := range md.Status.Conditions {
 		// 2. 找到要删除的对象
-		if tmp[i].Type == conditionType {
-			// 3. 执行删除
-			md.Status.Conditions = deleteCondition(tmp, i)
+		if md.Status.Conditions[i].Type == conditionType {
+			// 3. 执行删除, conditionType 是唯一的, 删除后直接返回
+			md.Status.Conditions = deleteCondition(md.Status.Conditions, i)
+			md.Status.ObservedGeneration += 1
+			return
 		}
 	}
 }
@@ -584,7 +2610,7 @@ func (r *ZwhDeploymentReconciler) deleteStatus(md *myAppsv1.ZwhDeployment, condi
 func (r *ZwhDeploymentReconciler) createIssuer(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
 	// 1. 创建 issuer 资源
 	i, err := NewIssuer(md)
-	if err != nil {
+	if err != nil || i == nil {
 		return err
 	}
 
@@ -594,45 +2620,286 @@ func (r *ZwhDeploymentReconciler) createIssuer(ctx context.Context, md *myAppsv1
 	}
 
 	// 在k8s中创建issuer资源
-	if _, err := r.DynamicClient.Resource(issuerGVR).
+	_, err = r.DynamicClient.Resource(issuerGVR).
 		Namespace(md.Namespace).
-		Create(ctx, i, metav1.CreateOptions{}); err != nil {
-		if errors.IsAlreadyExists(err) {
-			// 这是一个折中的考虑，在没有比较完整的处理证书更新的方案前，
-			// 这是一个简单并且不会出现意外错误的处理方式
-			return nil
+		Create(ctx, i, metav1.CreateOptions{})
+	return err
+}
+
+// applyIssuer issuer 不存在时创建,存在时更新
+func (r *ZwhDeploymentReconciler) applyIssuer(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	old, err := r.DynamicClient.Resource(issuerGVR).
+		Namespace(md.Namespace).
+		Get(ctx, md.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.createIssuer(ctx, md)
 		}
 		return err
 	}
-	return nil
+	return r.updateIssuer(ctx, md, old)
+}
+
+func (r *ZwhDeploymentReconciler) updateIssuer(ctx context.Context, md *myAppsv1.ZwhDeployment, old *unstructured.Unstructured) error {
+	i, err := NewIssuer(md)
+	if err != nil || i == nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Object["spec"], i.Object["spec"]) {
+		return nil
+	}
+	if err := controllerutil.SetControllerReference(md, i, r.Scheme); err != nil {
+		return err
+	}
+	i.SetResourceVersion(old.GetResourceVersion())
+	_, err = r.DynamicClient.Resource(issuerGVR).
+		Namespace(md.Namespace).
+		Update(ctx, i, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteIssuer 需要是幂等的,不存在时什么也不做
+// tls.issuerName 可能引用用户创建的同名 issuer, 不是 operator 创建的时候不能删除
+func (r *ZwhDeploymentReconciler) deleteIssuer(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	return r.deleteOwnedResource(ctx, md, issuerGVR)
 }
 
 func (r *ZwhDeploymentReconciler) createCert(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
-	// 1. 创建 issuer 资源
+	// 1. 创建 certificate 资源
 	c, err := NewCert(md)
-	if err != nil {
+	if err != nil || c == nil {
 		return err
 	}
 
-	// 设置 issuer 所属于 md
+	// 设置 certificate 所属于 md
 	if err := controllerutil.SetControllerReference(md, c, r.Scheme); err != nil {
 		return err
 	}
 
 	// 在k8s中创建certificate资源
-	if _, err := r.DynamicClient.Resource(certGVR).
+	_, err = r.DynamicClient.Resource(certGVR).
 		Namespace(md.Namespace).
-		Create(ctx, c, metav1.CreateOptions{}); err != nil {
-		if errors.IsAlreadyExists(err) {
-			// 这是一个折中的考虑，在没有比较完整的处理证书更新的方案前，
-			// 这是一个简单并且不会出现意外错误的处理方式
-			return nil
+		Create(ctx, c, metav1.CreateOptions{})
+	return err
+}
+
+// applyCert certificate 不存在时创建,存在时更新
+func (r *ZwhDeploymentReconciler) applyCert(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	old, err := r.DynamicClient.Resource(certGVR).
+		Namespace(md.Namespace).
+		Get(ctx, md.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.createCert(ctx, md)
+		}
+		return err
+	}
+	return r.updateCert(ctx, md, old)
+}
+
+func (r *ZwhDeploymentReconciler) updateCert(ctx context.Context, md *myAppsv1.ZwhDeployment, old *unstructured.Unstructured) error {
+	c, err := NewCert(md)
+	if err != nil || c == nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Object["spec"], c.Object["spec"]) {
+		return nil
+	}
+	if err := controllerutil.SetControllerReference(md, c, r.Scheme); err != nil {
+		return err
+	}
+	c.SetResourceVersion(old.GetResourceVersion())
+	_, err = r.DynamicClient.Resource(certGVR).
+		Namespace(md.Namespace).
+		Update(ctx, c, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteCert 需要是幂等的,不存在时什么也不做
+func (r *ZwhDeploymentReconciler) deleteCert(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	return r.deleteOwnedResource(ctx, md, certGVR)
+}
+
+// deleteOwnedResource 使用动态客户端删除和 md 同名并且由 md 创建的对象, 需要是幂等的
+// 同名的对象不是 operator 创建的时候不能删除
+func (r *ZwhDeploymentReconciler) deleteOwnedResource(ctx context.Context, md *myAppsv1.ZwhDeployment, gvr schema.GroupVersionResource) error {
+	obj, err := r.DynamicClient.Resource(gvr).
+		Namespace(md.Namespace).
+		Get(ctx, md.Name, metav1.GetOptions{})
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, md) {
+		return nil
+	}
+	// 只删除读取到的对象, 期间被重新创建的同名对象不受影响
+	uid := obj.GetUID()
+	err = r.DynamicClient.Resource(gvr).
+		Namespace(md.Namespace).
+		Delete(ctx, md.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	return client.IgnoreNotFound(err)
+}
+
+// certReady 读取 certificate 中 cert-manager 写入的 Ready condition,判断证书是否已经签发
+func (r *ZwhDeploymentReconciler) certReady(ctx context.Context, md *myAppsv1.ZwhDeployment) (bool, string, error) {
+	c, err := r.DynamicClient.Resource(certGVR).
+		Namespace(md.Namespace).
+		Get(ctx, md.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, "", client.IgnoreNotFound(err)
+	}
+	conditions, _, err := unstructured.NestedSlice(c.Object, "status", "conditions")
+	if err != nil {
+		return false, "", err
+	}
+	for i := range conditions {
+		condition, ok := conditions[i].(map[string]interface{})
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		message, _ := condition["message"].(string)
+		return condition["status"] == myAppsv1.ConditionStatusTrue, message, nil
+	}
+	return false, "", nil
+}
+
+// applyHTTPRoute httproute 不存在时创建,存在时更新, 返回线上的 httproute 用来读取状态
+func (r *ZwhDeploymentReconciler) applyHTTPRoute(ctx context.Context, md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
+	old, err := r.DynamicClient.Resource(httpRouteGVR).
+		Namespace(md.Namespace).
+		Get(ctx, md.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.createHTTPRoute(ctx, md)
 		}
+		return nil, err
+	}
+	return old, r.updateHTTPRoute(ctx, md, old)
+}
+
+func (r *ZwhDeploymentReconciler) createHTTPRoute(ctx context.Context, md *myAppsv1.ZwhDeployment) (*unstructured.Unstructured, error) {
+	route, err := NewHTTPRoute(md)
+	if err != nil {
+		return nil, err
+	}
+	// 设置 httproute 所属于 md
+	if err := controllerutil.SetControllerReference(md, route, r.Scheme); err != nil {
+		return nil, err
+	}
+	return r.DynamicClient.Resource(httpRouteGVR).
+		Namespace(md.Namespace).
+		Create(ctx, route, metav1.CreateOptions{})
+}
+
+func (r *ZwhDeploymentReconciler) updateHTTPRoute(ctx context.Context, md *myAppsv1.ZwhDeployment, old *unstructured.Unstructured) error {
+	route, err := NewHTTPRoute(md)
+	if err != nil {
+		return err
+	}
+	//预更新httproute。apiserver 会补齐默认值,用补齐后的数据比较
+	route.SetResourceVersion(old.GetResourceVersion())
+	if err := controllerutil.SetControllerReference(md, route, r.Scheme); err != nil {
 		return err
 	}
+	dryRun, err := r.DynamicClient.Resource(httpRouteGVR).
+		Namespace(md.Namespace).
+		Update(ctx, route, metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}})
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(old.Object["spec"], dryRun.Object["spec"]) {
+		return nil
+	}
+	_, err = r.DynamicClient.Resource(httpRouteGVR).
+		Namespace(md.Namespace).
+		Update(ctx, route, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteHTTPRoute 需要是幂等的,不存在或者不是 operator 创建的时候什么也不做
+// 集群没有安装 Gateway API 时也什么都不做
+func (r *ZwhDeploymentReconciler) deleteHTTPRoute(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	return r.deleteOwnedResource(ctx, md, httpRouteGVR)
+}
+
+// updateContainerStatuses 汇总 pod 中主容器、边车和初始化容器的状态
+// pod 不走缓存读取, 和 probeFailure 一样避免启动整个集群的 informer
+func (r *ZwhDeploymentReconciler) updateContainerStatuses(ctx context.Context, md *myAppsv1.ZwhDeployment) error {
+	pods := new(corev1.PodList)
+	if err := r.APIReader.List(ctx, pods,
+		client.InNamespace(md.Namespace),
+		client.MatchingLabels{"app": md.Name}); err != nil {
+		return err
+	}
+	md.Status.Containers = containerStatuses(md, pods.Items)
 	return nil
 }
 
+// probeFailure 找到第一个没有就绪的容器,返回它最近一次健康检查失败的信息
+// 没有健康检查失败时返回空字符串
+func (r *ZwhDeploymentReconciler) probeFailure(ctx context.Context, md *myAppsv1.ZwhDeployment) (string, error) {
+	pods := new(corev1.PodList)
+	if err := r.APIReader.List(ctx, pods,
+		client.InNamespace(md.Namespace),
+		client.MatchingLabels{"app": md.Name}); err != nil {
+		return "", err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Ready {
+				continue
+			}
+			// 健康检查失败的信息只记录在 event 中, 由 apiserver 按字段查询
+			events := new(corev1.EventList)
+			if err := r.APIReader.List(ctx, events,
+				client.InNamespace(md.Namespace),
+				client.MatchingFields{"involvedObject.name": pod.Name, "reason": "Unhealthy"}); err != nil {
+				return "", err
+			}
+			var latest *corev1.Event
+			for j := range events.Items {
+				if latest == nil || eventTime(&events.Items[j]).After(eventTime(latest)) {
+					latest = &events.Items[j]
+				}
+			}
+			if latest != nil {
+				return fmt.Sprintf("pod %s: %s", pod.Name, latest.Message), nil
+			}
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+				return fmt.Sprintf("pod %s container %s: %s", pod.Name, cs.Name, cs.State.Waiting.Reason), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// eventTime 获取 event 最后一次发生的时间
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	case !event.FirstTimestamp.IsZero():
+		return event.FirstTimestamp.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// waitRequeue 判断是否需要等待一段时间后重新入队
+// 发布失败后只有修改 spec 才会重新发布, 由 md 的变更触发 reconcile, 不需要重新入队
+func waitRequeue(conditions []myAppsv1.Condition) bool {
+	for i := range conditions {
+		if conditions[i].Status == myAppsv1.ConditionStatusFalse &&
+			conditions[i].Reason != myAppsv1.ConditionReasonRolloutFailed &&
+			conditions[i].Reason != myAppsv1.ConditionReasonRolledBack {
+			return true
+		}
+	}
+	return false
+}
+
 func isSuccess(conditions []myAppsv1.Condition) (message, reason, phase string, sus bool) {
 	if len(conditions) == 0 {
 		return "", "", "", false